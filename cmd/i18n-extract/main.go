@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/IrakliGiorgadze/go-web-app/i18n"
+)
+
+// i18n-extract finds the messages the app shows and reports the ones each
+// locale in the catalog hasn't translated. Messages are found in:
+//
+//   - templates, as the first argument of the t function;
+//   - Go code, as the string literal passed to localize, errors.Public,
+//     ErrorPages.Message, validate.Msgf, validate.Equals and
+//     Validator.Add.
+//
+// With -update the missing messages are added to each locale's file with
+// an empty translation, ready to be filled in. It exits with status 1 when
+// anything is untranslated, so it can be run in CI.
+func main() {
+	root := flag.String("root", ".", "directory containing the Go code")
+	templatesDir := flag.String("templates", "templates", "directory containing the templates")
+	localesDir := flag.String("locales", "i18n/locales", "directory containing the catalog")
+	update := flag.Bool("update", false, "add missing messages to the catalog files")
+	flag.Parse()
+
+	keys, err := extract(*root, *templatesDir)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	missing, err := check(*localesDir, keys, *update)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	if missing > 0 {
+		os.Exit(1)
+	}
+}
+
+// templateCall matches {{t "..."}} and {{t `...`}}, including inside
+// larger pipelines such as {{t "%d images" .Count}}.
+var templateCall = regexp.MustCompile("\\bt\\s+(\"(?:[^\"\\\\]|\\\\.)*\"|`[^`]*`)")
+
+// goCalls maps the functions that take a message to the position of the
+// message among their arguments. -1 means the last argument.
+var goCalls = map[string]int{
+	"localize": 1,
+	"Public":   -1,
+	"Message":  -1,
+	"Msgf":     0,
+	"Equals":   -1,
+	"Add":      1,
+}
+
+func extract(root, templatesDir string) (map[string][]string, error) {
+	keys := make(map[string][]string)
+	fset := token.NewFileSet()
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+
+		f, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return err
+		}
+
+		ast.Inspect(f, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+
+			var name string
+			switch fn := call.Fun.(type) {
+			case *ast.Ident:
+				name = fn.Name
+			case *ast.SelectorExpr:
+				name = fn.Sel.Name
+			}
+
+			i, ok := goCalls[name]
+			if !ok || len(call.Args) == 0 {
+				return true
+			}
+			if i < 0 {
+				i = len(call.Args) - 1
+			}
+			if i >= len(call.Args) {
+				return true
+			}
+
+			lit, ok := call.Args[i].(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				return true
+			}
+			key, err := strconv.Unquote(lit.Value)
+			if err == nil && key != "" {
+				pos := fset.Position(lit.Pos())
+				keys[key] = append(keys[key], fmt.Sprintf("%s:%d", pos.Filename, pos.Line))
+			}
+			return true
+		})
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("extract go: %w", err)
+	}
+
+	err = filepath.WalkDir(templatesDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".gohtml" {
+			return err
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		for i, line := range strings.Split(string(b), "\n") {
+			for _, m := range templateCall.FindAllStringSubmatch(line, -1) {
+				key, err := strconv.Unquote(m[1])
+				if err != nil {
+					continue
+				}
+				keys[key] = append(keys[key], fmt.Sprintf("%s:%d", path, i+1))
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("extract templates: %w", err)
+	}
+
+	return keys, nil
+}
+
+// check reports the keys each locale other than the default is missing,
+// and returns how many there were in total.
+func check(localesDir string, keys map[string][]string, update bool) (int, error) {
+	catalog, err := i18n.Load(os.DirFS(localesDir))
+	if err != nil {
+		return 0, err
+	}
+
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	total := 0
+	for _, locale := range catalog.Locales() {
+		if locale == i18n.DefaultLocale {
+			continue
+		}
+
+		var missing []string
+		for _, key := range sorted {
+			if !catalog.Has(locale, key) {
+				missing = append(missing, key)
+			}
+		}
+
+		fmt.Printf("%s: %d of %d messages untranslated\n", locale, len(missing), len(sorted))
+		for _, key := range missing {
+			fmt.Printf("  %q (%s)\n", key, keys[key][0])
+		}
+		total += len(missing)
+
+		if update && len(missing) > 0 {
+			err := addMissing(filepath.Join(localesDir, locale+".json"), missing)
+			if err != nil {
+				return total, err
+			}
+		}
+	}
+
+	return total, nil
+}
+
+// addMissing adds keys to a catalog file with empty translations, keeping
+// everything else in the file.
+func addMissing(path string, keys []string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("add missing: %w", err)
+	}
+
+	var file map[string]json.RawMessage
+	err = json.Unmarshal(b, &file)
+	if err != nil {
+		return fmt.Errorf("add missing: %s: %w", path, err)
+	}
+
+	messages := make(map[string]json.RawMessage)
+	if raw, ok := file["messages"]; ok {
+		err = json.Unmarshal(raw, &messages)
+		if err != nil {
+			return fmt.Errorf("add missing: %s: %w", path, err)
+		}
+	}
+	for _, key := range keys {
+		if _, ok := messages[key]; !ok {
+			messages[key] = json.RawMessage(`""`)
+		}
+	}
+
+	file["messages"], err = json.Marshal(messages)
+	if err != nil {
+		return fmt.Errorf("add missing: %w", err)
+	}
+
+	b, err = json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("add missing: %w", err)
+	}
+
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
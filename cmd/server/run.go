@@ -1,22 +1,51 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/IrakliGiorgadze/go-web-app/config"
 	"github.com/IrakliGiorgadze/go-web-app/controllers"
+	"github.com/IrakliGiorgadze/go-web-app/i18n"
+	"github.com/IrakliGiorgadze/go-web-app/i18n/locales"
 	"github.com/IrakliGiorgadze/go-web-app/migrations"
 	"github.com/IrakliGiorgadze/go-web-app/models"
 	"github.com/IrakliGiorgadze/go-web-app/templates"
 	"github.com/IrakliGiorgadze/go-web-app/views"
-	
+	"github.com/IrakliGiorgadze/go-web-app/worker"
+
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/gorilla/csrf"
 )
 
-func run(cfg config) error {
+// Where templates are read from in development mode, relative to the
+// working directory.
+const (
+	templatesDir = "templates"
+	emailsDir    = "emails"
+)
+
+// shutdownTimeout is how long requests in progress get to finish once the
+// server is asked to stop.
+const shutdownTimeout = 30 * time.Second
+
+func run(cfg config.Config) error {
+	// Without a base URL links are built from the request's Host header,
+	// which a client can set to anything.
+	if cfg.Server.BaseURL == nil && !cfg.Server.Dev {
+		return fmt.Errorf("BASE_URL must be set unless DEV_MODE is true")
+	}
+
 	// Set up the DB
 	db, err := models.Open(cfg.PSQL)
 	if err != nil {
@@ -36,6 +65,10 @@ func run(cfg config) error {
 	}
 
 	// Set up services
+	jobService := &models.JobService{
+		DB: db,
+	}
+
 	userService := &models.UserService{
 		DB: db,
 	}
@@ -44,25 +77,111 @@ func run(cfg config) error {
 		DB: db,
 	}
 
-	pwResetService := &models.PasswordResetService{
+	invitationService := &models.GalleryInvitationService{
 		DB: db,
 	}
 
-	galleryService := &models.GalleryService{
+	shareLinkService := &models.ShareLinkService{
 		DB: db,
 	}
 
-	emailService := models.NewEmailService(cfg.SMTP)
+	selectionService := &models.SelectionService{
+		DB: db,
+	}
+	commentService := &models.CommentService{
+		DB: db,
+	}
+	likeService := &models.LikeService{
+		DB: db,
+	}
+	viewCounter := &models.ViewCounter{
+		DB: db,
+	}
+
+	galleryService := &models.GalleryService{
+		DB:   db,
+		Jobs: jobService,
+	}
+
+	err = galleryService.ImportLegacyImages()
+	if err != nil {
+		return err
+	}
+
+	uploadService := &models.UploadService{
+		DB:             db,
+		GalleryService: galleryService,
+	}
+
+	transport, err := models.NewTransport(cfg.Email.Transport, cfg.Email.Dir, cfg.SMTP)
+	if err != nil {
+		return err
+	}
+
+	emailService := models.NewEmailService(transport)
+	emailService.DefaultSender = cfg.Email.Sender
+	emailService.DB = db
+	emailService.Jobs = jobService
+
+	pwResetService := &models.PasswordResetService{
+		DB:           db,
+		EmailService: emailService,
+		Jobs:         jobService,
+	}
+
+	// Set up templates
+	tplParser := views.Parser{
+		FS: templates.FS,
+	}
+	if cfg.Server.Dev {
+		tplParser = views.Parser{
+			FS:     os.DirFS(templatesDir),
+			Reload: true,
+		}
+		emailService.Templates = os.DirFS(emailsDir)
+	}
+
+	err = views.Check(tplParser.FS, "tailwind.gohtml")
+	if err != nil {
+		return err
+	}
+
+	catalog, err := i18n.Load(locales.FS)
+	if err != nil {
+		return err
+	}
 
 	// Set up middleware
+	bmw := controllers.BaseURLMiddleware{
+		BaseURL:        cfg.Server.BaseURL,
+		TrustedProxies: cfg.Server.TrustedProxies,
+	}
+
 	umw := controllers.UserMiddleware{
 		SessionService: sessionService,
 	}
 
+	flasher := controllers.Flasher{
+		Key: []byte(cfg.Flash.Key),
+	}
+
+	lmw := controllers.LocaleMiddleware{
+		Catalog: catalog,
+	}
+
+	errorPages := controllers.ErrorPages{
+		Template: views.Must(tplParser.Parse(
+			"error.gohtml", "tailwind.gohtml",
+		)),
+	}
+
 	csrfMw := csrf.Protect(
 		[]byte(cfg.CSRF.Key),
 		csrf.Secure(cfg.CSRF.Secure),
 		csrf.Path("/"),
+		csrf.ErrorHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			errorPages.Message(w, r, http.StatusForbidden, "Your session has expired. Go back, reload the page and try again.")
+		})),
 	)
 
 	// Set up controllers
@@ -72,73 +191,132 @@ func run(cfg config) error {
 		SessionService:       sessionService,
 		PasswordResetService: pwResetService,
 		EmailService:         emailService,
+		Errors:               errorPages,
+		Flash:                flasher,
 	}
-	usersC.Templates.New = views.Must(views.ParseFS(
-		templates.FS,
+	usersC.Templates.New = views.Must(tplParser.Parse(
 		"signup.gohtml", "tailwind.gohtml",
 	))
-	usersC.Templates.SignIn = views.Must(views.ParseFS(
-		templates.FS,
+	usersC.Templates.SignIn = views.Must(tplParser.Parse(
 		"signin.gohtml", "tailwind.gohtml",
 	))
 
-	usersC.Templates.ForgotPassword = views.Must(views.ParseFS(
-		templates.FS,
+	usersC.Templates.ForgotPassword = views.Must(tplParser.Parse(
 		"forgot-pw.gohtml", "tailwind.gohtml",
 	))
 
-	usersC.Templates.CheckYourEmail = views.Must(views.ParseFS(
-		templates.FS,
+	usersC.Templates.CheckYourEmail = views.Must(tplParser.Parse(
 		"check-your-email.gohtml", "tailwind.gohtml",
 	))
 
-	usersC.Templates.ResetPassword = views.Must(views.ParseFS(
-		templates.FS,
+	usersC.Templates.ResetPassword = views.Must(tplParser.Parse(
 		"reset-pw.gohtml", "tailwind.gohtml",
 	))
 
+	usersC.Templates.Profile = views.Must(tplParser.Parse(
+		"profiles/edit.gohtml", "tailwind.gohtml",
+	))
+
+	// Profiles Controllers
+	profilesC := controllers.Profiles{
+		UserService:    userService,
+		GalleryService: galleryService,
+		Errors:         errorPages,
+	}
+
+	profilesC.Templates.Show = views.Must(tplParser.Parse(
+		"profiles/show.gohtml", "tailwind.gohtml",
+	))
+
 	// Galleries Controllers
 	galleriesC := controllers.Galleries{
-		GalleryService: galleryService,
+		GalleryService:    galleryService,
+		UploadService:     uploadService,
+		InvitationService: invitationService,
+		ShareLinkService:  shareLinkService,
+		SelectionService:  selectionService,
+		CommentService:    commentService,
+		LikeService:       likeService,
+		Views:             viewCounter,
+		EmailService:      emailService,
+		UploadLimits:      cfg.Upload,
+		Errors:            errorPages,
+		Flash:             flasher,
+		AccessKey:         []byte(cfg.Gallery.AccessKey),
 	}
 
-	galleriesC.Templates.New = views.Must(views.ParseFS(
-		templates.FS,
+	galleriesC.Templates.New = views.Must(tplParser.Parse(
 		"galleries/new.gohtml", "tailwind.gohtml",
 	))
 
-	galleriesC.Templates.Edit = views.Must(views.ParseFS(
-		templates.FS,
+	galleriesC.Templates.Edit = views.Must(tplParser.Parse(
 		"galleries/edit.gohtml", "tailwind.gohtml",
 	))
 
-	galleriesC.Templates.Index = views.Must(views.ParseFS(
-		templates.FS,
+	galleriesC.Templates.Index = views.Must(tplParser.Parse(
 		"galleries/index.gohtml", "tailwind.gohtml",
 	))
 
-	galleriesC.Templates.Show = views.Must(views.ParseFS(
-		templates.FS,
-		"galleries/show.gohtml", "tailwind.gohtml",
+	galleriesC.Templates.Show = views.Must(tplParser.Parse(
+		"galleries/show.gohtml", "galleries/comments.gohtml", "tailwind.gohtml",
+	))
+
+	galleriesC.Templates.Search = views.Must(tplParser.Parse(
+		"galleries/search.gohtml", "tailwind.gohtml",
+	))
+
+	galleriesC.Templates.Members = views.Must(tplParser.Parse(
+		"galleries/members.gohtml", "tailwind.gohtml",
+	))
+
+	galleriesC.Templates.Invitation = views.Must(tplParser.Parse(
+		"galleries/invitation.gohtml", "tailwind.gohtml",
+	))
+	galleriesC.Templates.Unlock = views.Must(tplParser.Parse(
+		"galleries/unlock.gohtml", "tailwind.gohtml",
+	))
+	galleriesC.Templates.ShareLinks = views.Must(tplParser.Parse(
+		"galleries/links.gohtml", "tailwind.gohtml",
+	))
+	galleriesC.Templates.Selection = views.Must(tplParser.Parse(
+		"galleries/selection.gohtml", "tailwind.gohtml",
+	))
+	galleriesC.Templates.Image = views.Must(tplParser.Parse(
+		"galleries/image.gohtml", "galleries/comments.gohtml", "tailwind.gohtml",
+	))
+	galleriesC.Templates.Explore = views.Must(tplParser.Parse(
+		"galleries/explore.gohtml", "tailwind.gohtml",
 	))
 
+	// Locales Controllers
+	localesC := controllers.Locales{
+		Catalog:     catalog,
+		UserService: userService,
+		Errors:      errorPages,
+	}
+
 	// Set up router and routes
 	r := chi.NewRouter()
+	localesC.Routes = r
+	r.Use(middleware.RequestID)
+	r.Use(errorPages.Recover)
+	r.Use(bmw.SetBaseURL)
+	r.Use(bmw.SetClientAddr)
+	r.Use(galleriesC.LimitUploads)
 	r.Use(csrfMw)
 	r.Use(umw.SetUser)
+	r.Use(flasher.LoadFlashes)
+	r.Use(lmw.SetLocale)
 
-	r.Get("/", controllers.StaticHandler(views.Must(views.ParseFS(
-		templates.FS,
+	r.Get("/", controllers.StaticHandler(views.Must(tplParser.Parse(
 		"home.gohtml", "tailwind.gohtml",
 	))))
 
-	r.Get("/contact", controllers.StaticHandler(views.Must(views.ParseFS(
-		templates.FS,
+	r.Get("/contact", controllers.StaticHandler(views.Must(tplParser.Parse(
 		"contact.gohtml", "tailwind.gohtml",
 	))))
 
-	r.Get("/faq", controllers.FAQ(views.Must(views.ParseFS(
-		templates.FS,
+	r.Get("/faq", controllers.FAQ(views.Must(tplParser.Parse(
 		"faq.gohtml", "tailwind.gohtml",
 	))))
 
@@ -151,15 +329,31 @@ func run(cfg config) error {
 	r.Post("/forgot-pw", usersC.ProcessForgotPassword)
 	r.Get("/reset-pw", usersC.ResetPassword)
 	r.Post("/reset-pw", usersC.ProcessResetPassword)
+	r.Post("/locale", localesC.Update)
 
 	r.Route("/users/me", func(r chi.Router) {
 		r.Use(umw.RequireUser)
 		r.Get("/", usersC.CurrentUser)
+		r.Post("/", usersC.UpdateProfile)
+		r.Post("/avatar", usersC.UpdateAvatar)
+		r.Post("/avatar/delete", usersC.DeleteAvatar)
+	})
+
+	r.Route("/u/{username}", func(r chi.Router) {
+		r.Get("/", profilesC.Show)
+		r.Get("/avatar", profilesC.Avatar)
 	})
 
 	r.Route("/galleries", func(r chi.Router) {
+		r.Get("/search", galleriesC.Search)
 		r.Get("/{id}", galleriesC.Show)
+		r.Get("/{id}/download", galleriesC.Download)
 		r.Get("/{id}/images/{filename}", galleriesC.Image)
+		r.Post("/{id}/unlock", galleriesC.Unlock)
+		r.Post("/{id}/images/{filename}/favorite", galleriesC.ToggleFavorite)
+		r.Get("/{id}/selection", galleriesC.Selection)
+		r.Post("/{id}/selection", galleriesC.SubmitSelection)
+		r.Get("/{id}/images/{filename}/comments", galleriesC.ImageComments)
 		r.Group(func(r chi.Router) {
 			r.Use(umw.RequireUser)
 			r.Get("/", galleriesC.Index)
@@ -168,22 +362,125 @@ func run(cfg config) error {
 			r.Get("/{id}/edit", galleriesC.Edit)
 			r.Post("/{id}", galleriesC.Update)
 			r.Post("/{id}/delete", galleriesC.Delete)
+			r.Post("/{id}/password", galleriesC.SetPassword)
+			r.Post("/{id}/cover", galleriesC.SetCover)
 			r.Post("/{id}/images", galleriesC.UploadImage)
+			r.Post("/{id}/import", galleriesC.ImportZip)
+			r.Options("/{id}/uploads", galleriesC.UploadOptions)
+			r.Post("/{id}/uploads", galleriesC.CreateUpload)
+			r.Head("/{id}/uploads/{token}", galleriesC.UploadStatus)
+			r.Patch("/{id}/uploads/{token}", galleriesC.AppendUpload)
+			r.Delete("/{id}/uploads/{token}", galleriesC.DeleteUpload)
+			r.Post("/{id}/images/order", galleriesC.ReorderImages)
+			r.Post("/{id}/images/{filename}", galleriesC.UpdateImage)
 			r.Post("/{id}/images/{filename}/delete", galleriesC.DeleteImage)
+			r.Get("/{id}/members", galleriesC.Members)
+			r.Post("/{id}/members", galleriesC.Invite)
+			r.Post("/{id}/members/{userID}", galleriesC.UpdateMember)
+			r.Post("/{id}/members/{userID}/delete", galleriesC.RemoveMember)
+			r.Post("/{id}/invitations/{invitationID}/delete", galleriesC.RevokeInvitation)
+			r.Get("/{id}/links", galleriesC.ShareLinks)
+			r.Post("/{id}/links", galleriesC.CreateShareLink)
+			r.Post("/{id}/links/{linkID}/revoke", galleriesC.RevokeShareLink)
+			r.Get("/{id}/selections/{selectionID}/export", galleriesC.ExportSelection)
+			r.Post("/{id}/comments", galleriesC.CreateComment)
+			r.Post("/{id}/like", galleriesC.LikeGallery)
+			r.Post("/{id}/images/{filename}/like", galleriesC.LikeImage)
+			r.Post("/{id}/comments/mode", galleriesC.SetCommentMode)
+			r.Post("/{id}/comments/{commentID}/approve", galleriesC.ApproveComment)
+			r.Post("/{id}/comments/{commentID}/delete", galleriesC.DeleteComment)
 		})
 	})
 
+	r.Get("/share/{token}", galleriesC.FollowShareLink)
+	r.Get("/explore", galleriesC.Explore)
+
+	r.Route("/invitations/{token}", func(r chi.Router) {
+		r.Get("/", galleriesC.Invitation)
+		r.With(umw.RequireUser).Post("/", galleriesC.AcceptInvitation)
+	})
+
 	assetsHandler := http.FileServer(http.Dir("assets"))
 	r.Get("/assets/*", http.StripPrefix("/assets", assetsHandler).ServeHTTP)
 
-	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
-		http.Error(w, "Page not found", http.StatusNotFound)
-	})
+	r.NotFound(errorPages.NotFound)
+	r.MethodNotAllowed(errorPages.MethodNotAllowed)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Set up background jobs
+	var background sync.WaitGroup
+	if cfg.Jobs.InProcess {
+		pool := &worker.Pool{
+			JobService: jobService,
+			Workers:    cfg.Jobs.Workers,
+		}
+		pool.Handle(models.JobSendEmail, emailService.SendJob)
+		pool.Handle(models.JobPasswordReset, pwResetService.RequestJob)
+		pool.Handle(models.JobProcessImage, galleryService.ProcessImageJob)
+		pool.Handle(models.JobDeleteExpiredUploads, uploadService.DeleteExpiredJob)
+		pool.Every(time.Hour, models.JobDeleteExpiredUploads)
+
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			err := pool.Run(ctx)
+			if err != nil {
+				log.Println("Cannot run job workers", err)
+			}
+		}()
+	}
+
+	// Write counted views in batches
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := viewCounter.Flush()
+				if err != nil {
+					log.Println("Cannot save views", err)
+				}
+			}
+		}
+	}()
 
 	// Start the server
-	fmt.Printf("Starting the server on %s...\n", cfg.Server.Address)
-	err = http.ListenAndServe(cfg.Server.Address, r)
-	if err != nil {
+	server := &http.Server{
+		Addr:    cfg.Server.Address,
+		Handler: r,
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		fmt.Printf("Starting the server on %s...\n", cfg.Server.Address)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err = <-serveErr:
+	case <-ctx.Done():
+		fmt.Println("Shutting down the server...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		err = server.Shutdown(shutdownCtx)
+	}
+
+	stop()
+	background.Wait()
+
+	// Save the views counted since the last flush.
+	flushErr := viewCounter.Flush()
+	if flushErr != nil {
+		log.Println("Cannot save views", flushErr)
+	}
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
 
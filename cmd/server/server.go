@@ -1,29 +1,11 @@
 package main
 
 import (
-	"fmt"
-	"os"
-	"strconv"
-
-	"github.com/IrakliGiorgadze/go-web-app/models"
-
-	"github.com/joho/godotenv"
+	"github.com/IrakliGiorgadze/go-web-app/config"
 )
 
-type config struct {
-	PSQL models.PostgresConfig
-	SMTP models.SMTPConfig
-	CSRF struct {
-		Key    string
-		Secure bool
-	}
-	Server struct {
-		Address string
-	}
-}
-
 func main() {
-	cfg, err := loadEnvConfig()
+	cfg, err := config.LoadEnv()
 	if err != nil {
 		panic(err)
 	}
@@ -33,41 +15,3 @@ func main() {
 		panic(err)
 	}
 }
-
-func loadEnvConfig() (config, error) {
-	var cfg config
-
-	err := godotenv.Load()
-	if err != nil {
-		return cfg, err
-	}
-
-	cfg.PSQL = models.PostgresConfig{
-		Host:     os.Getenv("PSQL_HOST"),
-		Port:     os.Getenv("PSQL_PORT"),
-		User:     os.Getenv("PSQL_USER"),
-		Password: os.Getenv("PSQL_PASSWORD"),
-		Database: os.Getenv("PSQL_DATABASE"),
-		SSLMode:  os.Getenv("PSQL_SSL_MODE"),
-	}
-
-	if cfg.PSQL.Host == "" && cfg.PSQL.Port == "" {
-		return cfg, fmt.Errorf("no PSQL Config provided")
-	}
-
-	cfg.SMTP.Host = os.Getenv("SMTP_HOST")
-	portStr := os.Getenv("SMTP_PORT")
-	cfg.SMTP.Port, err = strconv.Atoi(portStr)
-	if err != nil {
-		return cfg, err
-	}
-	cfg.SMTP.Username = os.Getenv("SMTP_USERNAME")
-	cfg.SMTP.Password = os.Getenv("SMTP_PASSWORD")
-
-	cfg.CSRF.Key = os.Getenv("CSRF_KEY")
-	cfg.CSRF.Secure = os.Getenv("CSRF_SECURE") == "true"
-
-	cfg.Server.Address = os.Getenv("SERVER_ADDRESS")
-
-	return cfg, nil
-}
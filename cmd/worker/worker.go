@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/IrakliGiorgadze/go-web-app/config"
+	"github.com/IrakliGiorgadze/go-web-app/models"
+	"github.com/IrakliGiorgadze/go-web-app/worker"
+)
+
+// The worker processes background jobs outside of the web server. Set
+// JOBS_IN_PROCESS=false on the server when running it. It shares the
+// server's configuration and must be able to reach the same images
+// directory. Migrations are left to the server.
+func main() {
+	retryDead := flag.Bool("retry-dead", false, "requeue dead-lettered jobs and exit")
+	flag.Parse()
+
+	cfg, err := config.LoadEnv()
+	if err != nil {
+		panic(err)
+	}
+
+	err = run(cfg, *retryDead)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func run(cfg config.Config, retryDead bool) error {
+	db, err := models.Open(cfg.PSQL)
+	if err != nil {
+		return err
+	}
+
+	defer func(db *sql.DB) {
+		err = db.Close()
+		if err != nil {
+			log.Println("Cannot close DB connection", err)
+		}
+	}(db)
+
+	jobService := &models.JobService{
+		DB: db,
+	}
+
+	if retryDead {
+		n, err := jobService.RetryDead()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Requeued %d dead jobs\n", n)
+		return nil
+	}
+
+	galleryService := &models.GalleryService{
+		DB:   db,
+		Jobs: jobService,
+	}
+
+	uploadService := &models.UploadService{
+		DB:             db,
+		GalleryService: galleryService,
+	}
+
+	transport, err := models.NewTransport(cfg.Email.Transport, cfg.Email.Dir, cfg.SMTP)
+	if err != nil {
+		return err
+	}
+
+	emailService := models.NewEmailService(transport)
+	emailService.DefaultSender = cfg.Email.Sender
+	emailService.DB = db
+	emailService.Jobs = jobService
+
+	pwResetService := &models.PasswordResetService{
+		DB:           db,
+		EmailService: emailService,
+		Jobs:         jobService,
+	}
+
+	pool := &worker.Pool{
+		JobService: jobService,
+		Workers:    cfg.Jobs.Workers,
+	}
+	pool.Handle(models.JobSendEmail, emailService.SendJob)
+	pool.Handle(models.JobPasswordReset, pwResetService.RequestJob)
+	pool.Handle(models.JobProcessImage, galleryService.ProcessImageJob)
+	pool.Handle(models.JobDeleteExpiredUploads, uploadService.DeleteExpiredJob)
+	pool.Every(time.Hour, models.JobDeleteExpiredUploads)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Println("Starting the worker...")
+	return pool.Run(ctx)
+}
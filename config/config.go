@@ -0,0 +1,225 @@
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/IrakliGiorgadze/go-web-app/controllers"
+	"github.com/IrakliGiorgadze/go-web-app/models"
+
+	"github.com/joho/godotenv"
+)
+
+type Config struct {
+	PSQL  models.PostgresConfig
+	SMTP  models.SMTPConfig
+	Email struct {
+		// Sender is the From address of outgoing emails, such as
+		// "Lenslocked <support@example.com>".
+		Sender string
+		// Transport is one of the models.Transport constants. Dir is
+		// where the file transport writes .eml files.
+		Transport string
+		Dir       string
+	}
+	CSRF struct {
+		Key    string
+		Secure bool
+	}
+	Flash struct {
+		// Key signs the flash message cookie. It defaults to a key
+		// derived from the CSRF key.
+		Key string
+	}
+	Gallery struct {
+		// AccessKey signs the cookies that let visitors into galleries
+		// with a password. It defaults to a key derived from the CSRF
+		// key.
+		AccessKey string
+	}
+	Server struct {
+		Address string
+		// BaseURL is the public URL of the app, such as
+		// "https://example.com/photos" when a reverse proxy serves it
+		// under a path prefix. It is required unless Dev is set, in
+		// which case links are built from the request instead.
+		BaseURL        *url.URL
+		TrustedProxies []*net.IPNet
+		// Dev reads templates from disk on every request instead of
+		// using the copies embedded in the binary, and shows template
+		// errors in the browser. Never enable it in production.
+		Dev bool
+	}
+	Upload controllers.UploadLimits
+	Jobs   struct {
+		// Workers is the number of jobs processed concurrently.
+		Workers int
+		// InProcess runs the job workers inside the web server. Disable it
+		// when jobs are processed by cmd/worker instead.
+		InProcess bool
+	}
+}
+
+// LoadEnv reads the configuration from the environment, loading a .env
+// file first.
+func LoadEnv() (Config, error) {
+	var cfg Config
+
+	err := godotenv.Load()
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.PSQL = models.PostgresConfig{
+		Host:     os.Getenv("PSQL_HOST"),
+		Port:     os.Getenv("PSQL_PORT"),
+		User:     os.Getenv("PSQL_USER"),
+		Password: os.Getenv("PSQL_PASSWORD"),
+		Database: os.Getenv("PSQL_DATABASE"),
+		SSLMode:  os.Getenv("PSQL_SSL_MODE"),
+	}
+
+	if cfg.PSQL.Host == "" && cfg.PSQL.Port == "" {
+		return cfg, fmt.Errorf("no PSQL Config provided")
+	}
+
+	cfg.SMTP.Host = os.Getenv("SMTP_HOST")
+	if v := os.Getenv("SMTP_PORT"); v != "" {
+		cfg.SMTP.Port, err = strconv.Atoi(v)
+		if err != nil {
+			return cfg, err
+		}
+	}
+	cfg.SMTP.Username = os.Getenv("SMTP_USERNAME")
+	cfg.SMTP.Password = os.Getenv("SMTP_PASSWORD")
+
+	cfg.Email.Sender = os.Getenv("EMAIL_SENDER")
+	cfg.Email.Transport = os.Getenv("EMAIL_TRANSPORT")
+	cfg.Email.Dir = os.Getenv("EMAIL_DIR")
+
+	cfg.CSRF.Key = os.Getenv("CSRF_KEY")
+	cfg.CSRF.Secure = os.Getenv("CSRF_SECURE") == "true"
+
+	cfg.Flash.Key = os.Getenv("FLASH_KEY")
+	if cfg.Flash.Key == "" {
+		cfg.Flash.Key = deriveKey(cfg.CSRF.Key, "flash")
+	}
+
+	cfg.Gallery.AccessKey = os.Getenv("GALLERY_ACCESS_KEY")
+	if cfg.Gallery.AccessKey == "" {
+		cfg.Gallery.AccessKey = deriveKey(cfg.CSRF.Key, "gallery-access")
+	}
+
+	cfg.Server.Address = os.Getenv("SERVER_ADDRESS")
+	cfg.Server.Dev = os.Getenv("DEV_MODE") == "true"
+
+	if v := os.Getenv("BASE_URL"); v != "" {
+		cfg.Server.BaseURL, err = parseBaseURL(v)
+		if err != nil {
+			return cfg, err
+		}
+	}
+	if v := os.Getenv("TRUSTED_PROXIES"); v != "" {
+		cfg.Server.TrustedProxies, err = parseNetworks(v)
+		if err != nil {
+			return cfg, err
+		}
+	}
+
+	if v := os.Getenv("UPLOAD_MAX_FILE_SIZE"); v != "" {
+		cfg.Upload.MaxFileSize, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return cfg, err
+		}
+	}
+	if v := os.Getenv("UPLOAD_MAX_REQUEST_SIZE"); v != "" {
+		cfg.Upload.MaxRequestSize, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return cfg, err
+		}
+	}
+	if v := os.Getenv("UPLOAD_MAX_ARCHIVE_SIZE"); v != "" {
+		cfg.Upload.MaxArchiveSize, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return cfg, err
+		}
+	}
+
+	cfg.Jobs.InProcess = os.Getenv("JOBS_IN_PROCESS") != "false"
+	if v := os.Getenv("JOBS_WORKERS"); v != "" {
+		cfg.Jobs.Workers, err = strconv.Atoi(v)
+		if err != nil {
+			return cfg, err
+		}
+	}
+
+	return cfg, nil
+}
+
+// deriveKey returns a key for a single purpose, named by label, so that one
+// secret can sign several kinds of cookie without a value signed for one
+// being accepted as another.
+func deriveKey(key, label string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(label))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func parseBaseURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("BASE_URL: %w", err)
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("BASE_URL must be an absolute http or https URL")
+	}
+	if u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return nil, fmt.Errorf("BASE_URL can't have credentials, a query or a fragment")
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = ""
+
+	return u, nil
+}
+
+// parseNetworks parses a comma separated list of CIDR blocks and IP
+// addresses.
+func parseNetworks(s string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("TRUSTED_PROXIES: invalid address %q", v)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
@@ -0,0 +1,38 @@
+package context
+
+import (
+	"context"
+)
+
+func WithClientAddr(ctx context.Context, addr string) context.Context {
+	return context.WithValue(ctx, clientAddrKey, addr)
+}
+
+// ClientAddr returns the IP address of the client, or the empty string if
+// the request didn't pass through the client address middleware.
+func ClientAddr(ctx context.Context) string {
+	val := ctx.Value(clientAddrKey)
+	addr, ok := val.(string)
+	if !ok {
+		return ""
+	}
+
+	return addr
+}
+
+func WithScheme(ctx context.Context, scheme string) context.Context {
+	return context.WithValue(ctx, schemeKey, scheme)
+}
+
+// Scheme returns the scheme the client reached the app with, "http" or
+// "https", or the empty string if the request didn't pass through the base
+// URL middleware.
+func Scheme(ctx context.Context) string {
+	val := ctx.Value(schemeKey)
+	scheme, ok := val.(string)
+	if !ok {
+		return ""
+	}
+
+	return scheme
+}
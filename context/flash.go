@@ -0,0 +1,23 @@
+package context
+
+import (
+	"context"
+
+	"github.com/IrakliGiorgadze/go-web-app/flash"
+)
+
+func WithFlashes(ctx context.Context, msgs []flash.Message) context.Context {
+	return context.WithValue(ctx, flashKey, msgs)
+}
+
+// Flashes returns the messages set before the redirect that led to this
+// request.
+func Flashes(ctx context.Context) []flash.Message {
+	val := ctx.Value(flashKey)
+	msgs, ok := val.([]flash.Message)
+	if !ok {
+		return nil
+	}
+
+	return msgs
+}
@@ -0,0 +1,23 @@
+package context
+
+import (
+	"context"
+
+	"github.com/IrakliGiorgadze/go-web-app/i18n"
+)
+
+func WithLocalizer(ctx context.Context, localizer *i18n.Localizer) context.Context {
+	return context.WithValue(ctx, localizerKey, localizer)
+}
+
+// Localizer returns the Localizer for the language the request is answered
+// in, or nil, which leaves messages in English.
+func Localizer(ctx context.Context) *i18n.Localizer {
+	val := ctx.Value(localizerKey)
+	localizer, ok := val.(*i18n.Localizer)
+	if !ok {
+		return nil
+	}
+
+	return localizer
+}
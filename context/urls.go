@@ -0,0 +1,22 @@
+package context
+
+import (
+	"context"
+	"net/url"
+)
+
+func WithBaseURL(ctx context.Context, baseURL *url.URL) context.Context {
+	return context.WithValue(ctx, baseURLKey, baseURL)
+}
+
+// BaseURL returns the public URL the app is served at, or nil if the
+// request didn't pass through the base URL middleware.
+func BaseURL(ctx context.Context) *url.URL {
+	val := ctx.Value(baseURLKey)
+	baseURL, ok := val.(*url.URL)
+	if !ok {
+		return nil
+	}
+
+	return baseURL
+}
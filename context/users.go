@@ -9,7 +9,12 @@ import (
 type key string
 
 const (
-	userKey key = "user"
+	userKey       key = "user"
+	baseURLKey    key = "baseURL"
+	flashKey      key = "flash"
+	localizerKey  key = "localizer"
+	clientAddrKey key = "clientAddr"
+	schemeKey     key = "scheme"
 )
 
 func WithUser(ctx context.Context, user *models.User) context.Context {
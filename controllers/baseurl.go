@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/IrakliGiorgadze/go-web-app/context"
+)
+
+// BaseURLMiddleware records the public URL of the app in the request
+// context so that links can be built with the urls package.
+type BaseURLMiddleware struct {
+	// BaseURL is the configured public URL. When set it is used as is and
+	// no headers are consulted, so links in emails can't be pointed
+	// elsewhere with a forged Host header. Only development servers run
+	// without it.
+	BaseURL *url.URL
+	// TrustedProxies are the networks whose X-Forwarded-* headers are
+	// believed. X-Forwarded-For and X-Forwarded-Proto always are, for the
+	// client address and whether cookies can be marked secure;
+	// X-Forwarded-Host and X-Forwarded-Prefix only when there is no
+	// BaseURL. The headers are ignored from every other client.
+	TrustedProxies []*net.IPNet
+}
+
+func (mw BaseURLMiddleware) SetBaseURL(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		ctx = context.WithBaseURL(ctx, mw.baseURL(r))
+		ctx = context.WithScheme(ctx, mw.scheme(r))
+		r = r.WithContext(ctx)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SetClientAddr records the address of the client in the request context.
+// Behind a trusted proxy that is the address the proxy got the request
+// from, taken from X-Forwarded-For.
+func (mw BaseURLMiddleware) SetClientAddr(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		ctx = context.WithClientAddr(ctx, mw.clientAddr(r))
+		r = r.WithContext(ctx)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (mw BaseURLMiddleware) clientAddr(r *http.Request) string {
+	addr := remoteHost(r.RemoteAddr)
+	if !mw.trusted(r.RemoteAddr) {
+		return addr
+	}
+
+	// Each proxy appends the address it got the request from, so the
+	// client is the last one that isn't another trusted proxy.
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		addr = hop
+		if !mw.trusted(hop) {
+			break
+		}
+	}
+
+	return addr
+}
+
+func (mw BaseURLMiddleware) baseURL(r *http.Request) *url.URL {
+	if mw.BaseURL != nil {
+		return mw.BaseURL
+	}
+
+	base := url.URL{
+		Scheme: mw.scheme(r),
+		Host:   r.Host,
+	}
+	if !mw.trusted(r.RemoteAddr) {
+		return &base
+	}
+
+	if host := forwardedValue(r, "X-Forwarded-Host"); host != "" && !strings.ContainsAny(host, "/\\@ ") {
+		base.Host = host
+	}
+	if prefix := forwardedValue(r, "X-Forwarded-Prefix"); strings.HasPrefix(prefix, "/") {
+		base.Path = strings.TrimSuffix(path.Clean(prefix), "/")
+	}
+
+	return &base
+}
+
+// scheme returns the scheme the client used to reach the app, which differs
+// from the request's own when a trusted proxy terminates TLS.
+func (mw BaseURLMiddleware) scheme(r *http.Request) string {
+	if mw.trusted(r.RemoteAddr) {
+		switch proto := forwardedValue(r, "X-Forwarded-Proto"); proto {
+		case "http", "https":
+			return proto
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+
+	return "http"
+}
+
+func (mw BaseURLMiddleware) trusted(remoteAddr string) bool {
+	ip := net.ParseIP(remoteHost(remoteAddr))
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range mw.TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// remoteHost strips the port from an address such as http.Request's
+// RemoteAddr.
+func remoteHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+
+	return host
+}
+
+// forwardedValue returns the value the nearest proxy added, which is the
+// last one when a header has been appended to along the way.
+func forwardedValue(r *http.Request, header string) string {
+	values := strings.Split(r.Header.Get(header), ",")
+	return strings.TrimSpace(values[len(values)-1])
+}
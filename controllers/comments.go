@@ -0,0 +1,353 @@
+package controllers
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/IrakliGiorgadze/go-web-app/context"
+	"github.com/IrakliGiorgadze/go-web-app/errors"
+	"github.com/IrakliGiorgadze/go-web-app/flash"
+	"github.com/IrakliGiorgadze/go-web-app/markdown"
+	"github.com/IrakliGiorgadze/go-web-app/models"
+	"github.com/IrakliGiorgadze/go-web-app/urls"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const MaxCommentLength = 2000
+
+// Comment is a comment as shown on a page, with the replies to it.
+type Comment struct {
+	ID         int
+	AuthorName string
+	// AuthorURL is the author's profile, if they have a username.
+	AuthorURL  string
+	Body       template.HTML
+	CreatedAt  time.Time
+	Pending    bool
+	CanReply   bool
+	CanApprove bool
+	CanDelete  bool
+	Replies    []Comment
+	// GalleryID, Filename and Return are what the forms on the comment
+	// post back.
+	GalleryID int
+	Filename  string
+	Return    string
+}
+
+// CommentSection is the comments on a gallery or one of its images, and the
+// form for adding one.
+type CommentSection struct {
+	GalleryID   int
+	Filename    string
+	Return      string
+	Mode        string
+	Open        bool
+	SignedIn    bool
+	CanModerate bool
+	Comments    []Comment
+}
+
+// commentSection loads the comments on the gallery, or on one of its images
+// when image isn't nil, that the visitor may see. Comments held for
+// moderation are only shown to the owner and their author.
+func (g Galleries) commentSection(r *http.Request, gallery *models.Gallery, image *models.Image, returnTo string) (*CommentSection, error) {
+	user := context.User(r.Context())
+	role, err := g.memberRole(r, gallery)
+	if err != nil {
+		return nil, err
+	}
+
+	section := CommentSection{
+		GalleryID:   gallery.ID,
+		Return:      returnTo,
+		Mode:        string(gallery.Comments),
+		Open:        gallery.Comments != models.CommentsOff,
+		SignedIn:    user != nil,
+		CanModerate: role.Can(models.RoleOwner),
+	}
+	if !section.Open {
+		return &section, nil
+	}
+
+	var imageID int
+	if image != nil {
+		imageID = image.ID
+		section.Filename = image.Filename
+	}
+
+	comments, err := g.CommentService.ByGallery(gallery.ID, imageID)
+	if err != nil {
+		return nil, err
+	}
+
+	replies := make(map[int][]models.Comment)
+	for _, comment := range comments {
+		if !comment.Approved && !section.CanModerate && (user == nil || comment.UserID != user.ID) {
+			continue
+		}
+		replies[comment.ParentID] = append(replies[comment.ParentID], comment)
+	}
+
+	var thread func(parentID, depth int) []Comment
+	thread = func(parentID, depth int) []Comment {
+		var comments []Comment
+		for _, comment := range replies[parentID] {
+			c := Comment{
+				ID:         comment.ID,
+				AuthorName: comment.AuthorName,
+				Body:       markdown.Render(comment.Body),
+				CreatedAt:  comment.CreatedAt,
+				Pending:    !comment.Approved,
+				CanReply:   section.SignedIn && depth+1 < models.MaxCommentDepth,
+				CanApprove: section.CanModerate && !comment.Approved,
+				CanDelete:  section.CanModerate || (user != nil && comment.UserID == user.ID),
+				Replies:    thread(comment.ID, depth+1),
+				GalleryID:  section.GalleryID,
+				Filename:   section.Filename,
+				Return:     section.Return,
+			}
+			if comment.AuthorUsername != "" {
+				c.AuthorURL = urls.Path(r, profilePath(&models.User{Username: comment.AuthorUsername}))
+			}
+			comments = append(comments, c)
+		}
+		return comments
+	}
+	section.Comments = thread(0, 0)
+
+	return &section, nil
+}
+
+// ImageComments shows an image with the comments on it.
+func (g Galleries) ImageComments(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+
+	link, err := g.visitorAccess(w, r, gallery)
+	if err != nil {
+		return
+	}
+
+	image, err := g.GalleryService.ImageByFilename(gallery.ID, g.filename(w, r))
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			g.Errors.Message(w, r, http.StatusNotFound, "Image not found")
+			return
+		}
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	var data struct {
+		GalleryID    int
+		GalleryTitle string
+		Image        Image
+		Originals    bool
+		Comments     *CommentSection
+	}
+	data.GalleryID = gallery.ID
+	data.GalleryTitle = gallery.Title
+	data.Image = newImage(image, gallery)
+	data.Originals = link == nil || link.AllowDownloads
+
+	returnTo := fmt.Sprintf("/galleries/%d/images/%s/comments", gallery.ID, url.PathEscape(image.Filename))
+	data.Comments, err = g.commentSection(r, gallery, &image, returnTo)
+	if err != nil {
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	g.Templates.Image.Execute(w, r, data)
+}
+
+// CreateComment posts a comment on the gallery or, when the form names
+// one, on an image. Comments from people who aren't members wait for the
+// owner's approval if the gallery is moderated.
+func (g Galleries) CreateComment(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+
+	_, err = g.visitorAccess(w, r, gallery)
+	if err != nil {
+		return
+	}
+
+	if gallery.Comments == models.CommentsOff {
+		g.Errors.Message(w, r, http.StatusForbidden, "Comments are turned off for this gallery.")
+		return
+	}
+
+	returnTo := galleryReturnPath(r, gallery)
+
+	role, err := g.memberRole(r, gallery)
+	if err != nil {
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	comment := models.Comment{
+		GalleryID: gallery.ID,
+		UserID:    context.User(r.Context()).ID,
+		Body:      strings.TrimSpace(r.FormValue("body")),
+		Approved:  gallery.Comments == models.CommentsOpen || role.Can(models.RoleViewer),
+	}
+
+	if filename := r.FormValue("image"); filename != "" {
+		image, err := g.GalleryService.ImageByFilename(gallery.ID, filename)
+		if err != nil {
+			if errors.Is(err, models.ErrNotFound) {
+				g.Errors.Message(w, r, http.StatusNotFound, "Image not found")
+				return
+			}
+			g.Errors.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		comment.ImageID = image.ID
+	}
+
+	if parent := r.FormValue("parent"); parent != "" {
+		comment.ParentID, err = strconv.Atoi(parent)
+		if err != nil {
+			g.Errors.Message(w, r, http.StatusNotFound, "Comment not found")
+			return
+		}
+	}
+
+	switch {
+	case comment.Body == "":
+		g.Flash.Add(w, r, flash.Error, localize(r, "Write something before posting your comment."))
+		urls.Redirect(w, r, returnTo, http.StatusFound)
+		return
+	case utf8.RuneCountInString(comment.Body) > MaxCommentLength:
+		g.Flash.Add(w, r, flash.Error, localize(r, "Comments can be at most %d characters long.", MaxCommentLength))
+		urls.Redirect(w, r, returnTo, http.StatusFound)
+		return
+	}
+
+	err = g.CommentService.Create(&comment, role.Can(models.RoleOwner))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrTooManyComments):
+			g.Flash.Add(w, r, flash.Error, localize(r, "You're commenting too quickly. Wait a few minutes and try again."))
+			urls.Redirect(w, r, returnTo, http.StatusFound)
+		case errors.Is(err, models.ErrNotFound):
+			g.Errors.Message(w, r, http.StatusNotFound, "Comment not found")
+		case errors.Is(err, models.ErrCommentTooDeep):
+			g.Flash.Add(w, r, flash.Error, localize(r, "That reply is nested too deeply. Reply to an earlier comment instead."))
+			urls.Redirect(w, r, returnTo, http.StatusFound)
+		default:
+			g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	if !comment.Approved {
+		g.Flash.Add(w, r, flash.Info, localize(r, "Thanks! Your comment will be shown once the owner approves it."))
+	}
+	urls.Redirect(w, r, fmt.Sprintf("%s#comment-%d", returnTo, comment.ID), http.StatusFound)
+}
+
+func (g Galleries) ApproveComment(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userMustHaveRole(models.RoleOwner))
+	if err != nil {
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "commentID"))
+	if err != nil {
+		g.Errors.Message(w, r, http.StatusNotFound, "Comment not found")
+		return
+	}
+
+	err = g.CommentService.Approve(gallery.ID, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			g.Errors.Message(w, r, http.StatusNotFound, "Comment not found")
+			return
+		}
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	urls.Redirect(w, r, fmt.Sprintf("%s#comment-%d", galleryReturnPath(r, gallery), id), http.StatusFound)
+}
+
+// DeleteComment lets the owner delete any comment, and everyone else their
+// own. Replies to the comment are deleted with it.
+func (g Galleries) DeleteComment(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "commentID"))
+	if err != nil {
+		g.Errors.Message(w, r, http.StatusNotFound, "Comment not found")
+		return
+	}
+
+	comment, err := g.CommentService.ByID(gallery.ID, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			g.Errors.Message(w, r, http.StatusNotFound, "Comment not found")
+			return
+		}
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if comment.UserID != context.User(r.Context()).ID {
+		err = g.userMustHaveRole(models.RoleOwner)(w, r, gallery)
+		if err != nil {
+			return
+		}
+	}
+
+	err = g.CommentService.Delete(gallery.ID, comment.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			g.Errors.Message(w, r, http.StatusNotFound, "Comment not found")
+			return
+		}
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	g.Flash.Add(w, r, flash.Success, localize(r, "Comment deleted."))
+	urls.Redirect(w, r, galleryReturnPath(r, gallery), http.StatusFound)
+}
+
+// SetCommentMode opens, moderates or turns off comments on the gallery.
+func (g Galleries) SetCommentMode(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userMustHaveRole(models.RoleOwner))
+	if err != nil {
+		return
+	}
+
+	mode := models.CommentMode(r.FormValue("comments"))
+	if !mode.Valid() {
+		g.Errors.Message(w, r, http.StatusBadRequest, "Choose whether to allow comments.")
+		return
+	}
+
+	err = g.GalleryService.SetCommentMode(gallery.ID, mode)
+	if err != nil {
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	g.Flash.Add(w, r, flash.Success, localize(r, "Comment settings saved."))
+	urls.Redirect(w, r, fmt.Sprintf("/galleries/%d/edit", gallery.ID), http.StatusFound)
+}
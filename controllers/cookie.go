@@ -3,25 +3,40 @@ package controllers
 import (
 	"fmt"
 	"net/http"
+	"strings"
+
+	"github.com/IrakliGiorgadze/go-web-app/context"
 )
 
 const (
 	CookieSession = "session"
+	CookieFlash   = "flash"
+	CookieLocale  = "locale"
+	// The gallery cookies are followed by the gallery ID, as in
+	// "gallery_access_12", so that each gallery has its own cookie.
+	CookieGalleryAccess = "gallery_access_"
+	CookieGalleryShare  = "gallery_share_"
+	// CookieGallerySelection keeps the favourites a visitor is picking
+	// from a gallery.
+	CookieGallerySelection = "gallery_selection_"
 )
 
-func newCookie(name, value string) *http.Cookie {
+// newCookie marks the cookie secure when the client reached the app over
+// HTTPS, so that it is never sent back in the clear.
+func newCookie(r *http.Request, name, value string) *http.Cookie {
 	cookie := http.Cookie{
 		Name:     name,
 		Value:    value,
 		Path:     "/",
 		HttpOnly: true,
+		Secure:   context.Scheme(r.Context()) == "https",
 	}
 
 	return &cookie
 }
 
-func setCookie(w http.ResponseWriter, name, value string) {
-	cookie := newCookie(name, value)
+func setCookie(w http.ResponseWriter, r *http.Request, name, value string) {
+	cookie := newCookie(r, name, value)
 	http.SetCookie(w, cookie)
 }
 
@@ -34,8 +49,20 @@ func readCookie(r *http.Request, name string) (string, error) {
 	return c.Value, nil
 }
 
-func deleteCookie(w http.ResponseWriter, name string) {
-	cookie := newCookie(name, "")
+func deleteCookie(w http.ResponseWriter, r *http.Request, name string) {
+	cookie := newCookie(r, name, "")
 	cookie.MaxAge = -1
 	http.SetCookie(w, cookie)
 }
+
+// removeSetCookie drops any cookie called name that has already been set on
+// the response, so that it can be set again.
+func removeSetCookie(w http.ResponseWriter, name string) {
+	var kept []string
+	for _, v := range w.Header().Values("Set-Cookie") {
+		if !strings.HasPrefix(v, name+"=") {
+			kept = append(kept, v)
+		}
+	}
+	w.Header()["Set-Cookie"] = kept
+}
@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/IrakliGiorgadze/go-web-app/models"
+)
+
+type manifestImage struct {
+	File     string `json:"file"`
+	Name     string `json:"name"`
+	Caption  string `json:"caption,omitempty"`
+	AltText  string `json:"alt_text,omitempty"`
+	Size     int64  `json:"size"`
+	Modified string `json:"modified"`
+}
+
+type manifest struct {
+	Gallery     string          `json:"gallery"`
+	Description string          `json:"description,omitempty"`
+	Tags        []string        `json:"tags,omitempty"`
+	CreatedAt   string          `json:"created_at"`
+	Images      []manifestImage `json:"images"`
+}
+
+// Download streams every image in the gallery as a ZIP archive. Entries are
+// written as they are read from disk so the archive is never buffered. Pass
+// manifest=true to include a manifest.json with captions and metadata.
+func (g Galleries) Download(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+
+	link, err := g.visitorAccess(w, r, gallery)
+	if err != nil {
+		return
+	}
+
+	if link != nil {
+		if !link.AllowDownloads {
+			g.Errors.Message(w, r, http.StatusForbidden, "Downloads aren't allowed through this link.")
+			return
+		}
+		err = g.ShareLinkService.CountDownload(link.ID)
+		if err != nil {
+			g.Errors.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	images, err := g.GalleryService.Images(gallery.ID)
+	if err != nil {
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": archiveName(gallery),
+	}))
+
+	err = writeArchive(w, gallery, images, r.FormValue("manifest") == "true")
+	if err != nil {
+		// The response has already started, so all we can do is log and
+		// leave the client with a truncated archive.
+		logf(r, "writing the archive of gallery %d: %v", gallery.ID, err)
+	}
+}
+
+func writeArchive(w io.Writer, gallery *models.Gallery, images []models.Image, withManifest bool) error {
+	zw := zip.NewWriter(w)
+
+	m := manifest{
+		Gallery:     gallery.Title,
+		Description: gallery.Description,
+		Tags:        gallery.Tags,
+		CreatedAt:   gallery.CreatedAt.Format(time.RFC3339),
+	}
+
+	used := make(map[string]bool)
+	for _, image := range images {
+		name := uniqueEntryName(used, image)
+		entry, err := addArchiveFile(zw, name, image.Path)
+		if err != nil {
+			return fmt.Errorf("write archive: %w", err)
+		}
+
+		entry.Name = image.Name
+		entry.Caption = image.Caption
+		entry.AltText = image.AltText
+		m.Images = append(m.Images, entry)
+	}
+
+	if withManifest {
+		header := &zip.FileHeader{
+			Name:     "manifest.json",
+			Method:   zip.Deflate,
+			Modified: time.Now(),
+		}
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return fmt.Errorf("write manifest: %w", err)
+		}
+
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(m)
+		if err != nil {
+			return fmt.Errorf("write manifest: %w", err)
+		}
+	}
+
+	err := zw.Close()
+	if err != nil {
+		return fmt.Errorf("write archive: %w", err)
+	}
+
+	return nil
+}
+
+func addArchiveFile(zw *zip.Writer, name, path string) (manifestImage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return manifestImage{}, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return manifestImage{}, err
+	}
+
+	// Images are already compressed, so they are stored as they are.
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: info.ModTime(),
+	}
+
+	fw, err := zw.CreateHeader(header)
+	if err != nil {
+		return manifestImage{}, err
+	}
+
+	_, err = io.Copy(fw, f)
+	if err != nil {
+		return manifestImage{}, err
+	}
+
+	return manifestImage{
+		File:     name,
+		Size:     info.Size(),
+		Modified: info.ModTime().UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// uniqueEntryName names archive entries after the image display names,
+// adding a counter when two images share a name.
+func uniqueEntryName(used map[string]bool, image models.Image) string {
+	name := strings.NewReplacer("/", "_", "\\", "_").Replace(image.Name)
+	if name == "" || name == "." || name == ".." || name == "manifest.json" {
+		name = image.Filename
+	}
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 2; used[strings.ToLower(candidate)]; i++ {
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	used[strings.ToLower(candidate)] = true
+
+	return candidate
+}
+
+func archiveName(gallery *models.Gallery) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || r == '\\' || r == '"' || r < 0x20:
+			return '_'
+		default:
+			return r
+		}
+	}, strings.TrimSpace(gallery.Title))
+	if name == "" {
+		name = fmt.Sprintf("gallery-%d", gallery.ID)
+	}
+
+	return name + ".zip"
+}
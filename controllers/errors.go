@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/IrakliGiorgadze/go-web-app/errors"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// ErrorPages shows errors to users in the site layout, or as JSON to API
+// clients. Every page includes the request ID so that a user reporting a
+// problem can be matched with the logs.
+type ErrorPages struct {
+	Template Template
+}
+
+// Render responds with status. Server errors are logged; err is only shown
+// to the user if it has a public message, otherwise a generic message for
+// the status is used.
+func (ep ErrorPages) Render(w http.ResponseWriter, r *http.Request, status int, err error) {
+	requestID := middleware.GetReqID(r.Context())
+	if status >= http.StatusInternalServerError {
+		log.Printf("[%s] %s %s: %v", requestID, r.Method, r.URL.Path, err)
+	}
+
+	var data struct {
+		Status    int    `json:"status"`
+		Title     string `json:"title"`
+		Message   string `json:"error"`
+		RequestID string `json:"request_id,omitempty"`
+	}
+	data.Status = status
+	data.Title = localize(r, http.StatusText(status))
+	data.Message = errorMessage(r, status)
+	data.RequestID = requestID
+
+	var pubErr interface{ Public() string }
+	if errors.As(err, &pubErr) {
+		data.Message = localize(r, pubErr.Public())
+	}
+
+	if requestID != "" {
+		w.Header().Set("X-Request-Id", requestID)
+	}
+
+	if ep.Template == nil || wantsJSON(r) || isTus(r) {
+		writeJSON(w, status, data)
+		return
+	}
+
+	// The page is rendered into a buffer so that nothing has been sent if
+	// the template fails, and a plain text error can go out instead.
+	var page pageBuffer
+	ep.Template.Execute(&page, r, data)
+	if page.status != 0 {
+		log.Printf("[%s] rendering the %d page failed with status %d", requestID, status, page.status)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "%s\n\n%s\n", data.Title, data.Message)
+		if requestID != "" {
+			fmt.Fprintf(w, "\nRequest ID: %s\n", requestID)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(status)
+	_, err = page.body.WriteTo(w)
+	if err != nil {
+		log.Printf("[%s] writing the %d page: %v", requestID, status, err)
+	}
+}
+
+// pageBuffer is a ResponseWriter that keeps what a template writes. A
+// template only sets a status when it fails.
+type pageBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (pb *pageBuffer) Header() http.Header {
+	if pb.header == nil {
+		pb.header = make(http.Header)
+	}
+
+	return pb.header
+}
+
+func (pb *pageBuffer) WriteHeader(status int) {
+	if pb.status == 0 {
+		pb.status = status
+	}
+}
+
+func (pb *pageBuffer) Write(b []byte) (int, error) {
+	return pb.body.Write(b)
+}
+
+// Message responds with status and a message that is safe to show.
+func (ep ErrorPages) Message(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	ep.Render(w, r, status, errors.Public(errors.New(msg), msg))
+}
+
+func (ep ErrorPages) NotFound(w http.ResponseWriter, r *http.Request) {
+	ep.Render(w, r, http.StatusNotFound, nil)
+}
+
+func (ep ErrorPages) MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	ep.Render(w, r, http.StatusMethodNotAllowed, nil)
+}
+
+// Recover turns a panic in a later handler into the 500 page.
+func (ep ErrorPages) Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			// The server uses this to abort a response on purpose.
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+
+			log.Printf("[%s] panic: %v\n%s", middleware.GetReqID(r.Context()), rec, debug.Stack())
+			ep.Render(w, r, http.StatusInternalServerError, errors.New("recovered from panic"))
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// logf logs a problem that the user isn't shown an error page for, with
+// the request ID like the error pages have.
+func logf(r *http.Request, format string, args ...any) {
+	args = append([]any{middleware.GetReqID(r.Context())}, args...)
+	log.Printf("[%s] "+format, args...)
+}
+
+func errorMessage(r *http.Request, status int) string {
+	switch status {
+	case http.StatusNotFound:
+		return localize(r, "We couldn't find the page you were looking for.")
+	case http.StatusForbidden:
+		return localize(r, "You don't have permission to do that.")
+	case http.StatusMethodNotAllowed:
+		return localize(r, "That isn't something you can do here.")
+	}
+
+	if status >= http.StatusInternalServerError {
+		return localize(r, "Something went wrong on our end. Please try again later.")
+	}
+
+	return localize(r, "Something was wrong with that request.")
+}
+
+func isTus(r *http.Request) bool {
+	return r.Header.Get("Tus-Resumable") != ""
+}
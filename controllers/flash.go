@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/IrakliGiorgadze/go-web-app/context"
+	"github.com/IrakliGiorgadze/go-web-app/flash"
+)
+
+// Flasher stores flash messages in a signed cookie. Set them with Add before
+// redirecting; LoadFlashes reads them on the next request and clears the
+// cookie so that they are only shown once.
+type Flasher struct {
+	Key []byte
+}
+
+func (f Flasher) LoadFlashes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		value, err := readCookie(r, CookieFlash)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		deleteCookie(w, r, CookieFlash)
+
+		msgs, err := flash.Decode(f.Key, value)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		ctx = context.WithFlashes(ctx, msgs)
+		r = r.WithContext(ctx)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Add queues a message for the next page the user sees, keeping any added
+// earlier in the same request.
+func (f Flasher) Add(w http.ResponseWriter, r *http.Request, level flash.Level, text string) {
+	msgs := append(f.pending(w), flash.Message{Level: level, Text: text})
+
+	value, err := flash.Encode(f.Key, msgs)
+	if err != nil {
+		logf(r, "encoding flash messages: %v", err)
+		return
+	}
+
+	removeSetCookie(w, CookieFlash)
+	setCookie(w, r, CookieFlash, value)
+}
+
+// pending returns the messages already set on the response.
+func (f Flasher) pending(w http.ResponseWriter) []flash.Message {
+	resp := http.Response{Header: w.Header()}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name != CookieFlash || cookie.MaxAge < 0 {
+			continue
+		}
+		msgs, err := flash.Decode(f.Key, cookie.Value)
+		if err == nil {
+			return msgs
+		}
+	}
+
+	return nil
+}
@@ -1,32 +1,101 @@
 package controllers
 
 import (
-	"errors"
 	"fmt"
+	"html/template"
+	"io"
+	"mime"
 	"net/http"
 	"net/url"
+	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/IrakliGiorgadze/go-web-app/context"
+	"github.com/IrakliGiorgadze/go-web-app/errors"
+	"github.com/IrakliGiorgadze/go-web-app/flash"
+	"github.com/IrakliGiorgadze/go-web-app/markdown"
 	"github.com/IrakliGiorgadze/go-web-app/models"
-
-	"github.com/go-chi/chi/v5"
+	"github.com/IrakliGiorgadze/go-web-app/urls"
+	"github.com/IrakliGiorgadze/go-web-app/validate"
 )
 
 type Galleries struct {
 	Templates struct {
-		Show  Template
-		New   Template
-		Edit  Template
-		Index Template
+		Show       Template
+		New        Template
+		Edit       Template
+		Index      Template
+		Search     Template
+		Members    Template
+		Invitation Template
+		Unlock     Template
+		ShareLinks Template
+		Selection  Template
+		Image      Template
+		Explore    Template
 	}
-	GalleryService *models.GalleryService
+	GalleryService    *models.GalleryService
+	UploadService     *models.UploadService
+	InvitationService *models.GalleryInvitationService
+	ShareLinkService  *models.ShareLinkService
+	SelectionService  *models.SelectionService
+	CommentService    *models.CommentService
+	LikeService       *models.LikeService
+	Views             *models.ViewCounter
+	EmailService      *models.EmailService
+	UploadLimits      UploadLimits
+	Errors            ErrorPages
+	Flash             Flasher
+	// AccessKey signs the cookies that let visitors who entered a
+	// gallery's password see it.
+	AccessKey []byte
 }
 
+const (
+	MaxTitleLength       = 100
+	MaxDescriptionLength = 10000
+	MaxImageFieldLength  = 255
+)
+
 type Image struct {
+	ID              int
 	GalleryID       int
 	Filename        string
 	FilenameEscaped string
+	Name            string
+	Caption         string
+	AltText         string
+	Cover           bool
+	Width           int
+	Height          int
+	UploaderName    string
+	// Favorite is set on the gallery page for images the visitor has
+	// added to their selection.
+	Favorite bool
+	// Comments is the number of approved comments on the image.
+	Comments int
+	Views    int
+	Likes    int
+	Liked    bool
+}
+
+func newImage(image models.Image, gallery *models.Gallery) Image {
+	return Image{
+		ID:              image.ID,
+		GalleryID:       image.GalleryID,
+		Filename:        image.Filename,
+		FilenameEscaped: url.PathEscape(image.Filename),
+		Name:            image.Name,
+		Caption:         image.Caption,
+		AltText:         image.AltText,
+		Cover:           image.Filename == gallery.Cover,
+		Width:           image.Width,
+		Height:          image.Height,
+		UploaderName:    image.UploaderName,
+		Views:           image.Views,
+	}
 }
 
 func (g Galleries) New(w http.ResponseWriter, r *http.Request) {
@@ -45,6 +114,13 @@ func (g Galleries) Create(w http.ResponseWriter, r *http.Request) {
 	data.UserID = context.User(r.Context()).ID
 	data.Title = r.FormValue("title")
 
+	var v validate.Validator
+	v.Field("title", data.Title, validate.Required(), validate.MaxLength(MaxTitleLength))
+	if !v.Valid() {
+		g.Templates.New.Execute(w, r, data, v.Err())
+		return
+	}
+
 	gallery, err := g.GalleryService.Create(data.Title, data.UserID)
 	if err != nil {
 		g.Templates.New.Execute(w, r, data, err)
@@ -52,7 +128,7 @@ func (g Galleries) Create(w http.ResponseWriter, r *http.Request) {
 	}
 
 	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
-	http.Redirect(w, r, editPath, http.StatusFound)
+	urls.Redirect(w, r, editPath, http.StatusFound)
 }
 
 func (g Galleries) Show(w http.ResponseWriter, r *http.Request) {
@@ -61,196 +137,614 @@ func (g Galleries) Show(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	link, err := g.visitorAccess(w, r, gallery)
+	if err != nil {
+		return
+	}
+
+	if link != nil {
+		err = g.ShareLinkService.CountView(link.ID)
+		if err != nil {
+			g.Errors.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	g.Views.GalleryView(gallery.ID, visitorKey(r))
+
 	var data struct {
-		ID     int
-		Title  string
-		Images []Image
+		ID          int
+		Title       string
+		Description template.HTML
+		Tags        []string
+		Images      []Image
+		// Originals is false for visitors whose share link doesn't allow
+		// downloads, who only get thumbnails.
+		Originals bool
+		Favorites int
+		Comments  *CommentSection
+		Views     int
+		Likes     models.Likes
 	}
 	data.ID = gallery.ID
 	data.Title = gallery.Title
+	data.Description = markdown.Render(gallery.Description)
+	data.Tags = gallery.Tags
+	data.Originals = link == nil || link.AllowDownloads
+	data.Views = gallery.Views
+
+	var userID int
+	if user := context.User(r.Context()); user != nil {
+		userID = user.ID
+	}
+
+	data.Likes, err = g.LikeService.Gallery(gallery.ID, userID)
+	if err != nil {
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	likes, err := g.LikeService.Images(gallery.ID, userID)
+	if err != nil {
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
 
 	images, err := g.GalleryService.Images(gallery.ID)
 	if err != nil {
-		fmt.Println(err)
-		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	selection, err := g.draftSelection(w, r, gallery, false)
+	if err != nil {
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
+	var counts map[int]int
+	if gallery.Comments != models.CommentsOff {
+		counts, err = g.CommentService.ImageCounts(gallery.ID)
+		if err != nil {
+			g.Errors.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
 	for _, image := range images {
-		data.Images = append(data.Images, Image{
-			GalleryID:       image.GalleryID,
-			Filename:        image.Filename,
-			FilenameEscaped: url.PathEscape(image.Filename),
-		})
+		img := newImage(image, gallery)
+		img.Favorite = selection != nil && selection.Has(image.ID)
+		img.Comments = counts[image.ID]
+		img.Likes = likes[image.ID].Count
+		img.Liked = likes[image.ID].Liked
+		data.Images = append(data.Images, img)
+	}
+	if selection != nil {
+		data.Favorites = len(selection.Images)
+	}
+
+	data.Comments, err = g.commentSection(r, gallery, nil, fmt.Sprintf("/galleries/%d", gallery.ID))
+	if err != nil {
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
 	}
 
 	g.Templates.Show.Execute(w, r, data)
 }
 
 func (g Galleries) Edit(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r, userMustOwnGallery)
+	gallery, err := g.galleryByID(w, r, g.userMustHaveRole(models.RoleEditor))
 	if err != nil {
 		return
 	}
 
+	g.renderEdit(w, r, gallery, nil, nil)
+}
+
+// renderEdit shows the edit page. When edited is set, its details replace
+// the stored ones so that a form that failed validation keeps what the user
+// typed.
+func (g Galleries) renderEdit(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, uploads []UploadResult, edited *models.Image, errs ...error) {
 	var data struct {
-		ID     int
-		Title  string
-		Images []Image
+		ID             int
+		Title          string
+		Description    string
+		Tags           string
+		Public         bool
+		HasPassword    bool
+		IsOwner        bool
+		Images         []Image
+		Uploads        []UploadResult
+		MaxFileSize    string
+		MaxUploadSize  string
+		MaxArchiveSize string
+		Selections     []models.Selection
+		CommentMode    string
 	}
 	data.ID = gallery.ID
 	data.Title = gallery.Title
+	data.Description = gallery.Description
+	data.Tags = strings.Join(gallery.Tags, ", ")
+	data.Public = gallery.Public
+	data.HasPassword = gallery.PasswordHash != ""
+	data.CommentMode = string(gallery.Comments)
+	data.IsOwner = gallery.UserID == context.User(r.Context()).ID
+	data.Uploads = uploads
+	data.MaxFileSize = formatBytes(g.UploadLimits.fileSize())
+	data.MaxUploadSize = formatBytes(g.UploadLimits.requestSize())
+	data.MaxArchiveSize = formatBytes(g.UploadLimits.archiveSize())
 
 	images, err := g.GalleryService.Images(gallery.ID)
 	if err != nil {
-		fmt.Println(err)
-		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
 	for _, image := range images {
-		data.Images = append(data.Images, Image{
-			GalleryID:       image.GalleryID,
-			Filename:        image.Filename,
-			FilenameEscaped: url.PathEscape(image.Filename),
-		})
+		if edited != nil && image.Filename == edited.Filename {
+			uploader := image.UploaderName
+			image = *edited
+			image.UploaderName = uploader
+		}
+		data.Images = append(data.Images, newImage(image, gallery))
 	}
 
-	g.Templates.Edit.Execute(w, r, data)
+	if data.IsOwner {
+		data.Selections, err = g.SelectionService.Submitted(gallery.ID)
+		if err != nil {
+			g.Errors.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	g.Templates.Edit.Execute(w, r, data, errs...)
 }
 
 func (g Galleries) Update(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r, userMustOwnGallery)
+	gallery, err := g.galleryByID(w, r, g.userMustHaveRole(models.RoleEditor))
 	if err != nil {
 		return
 	}
 
+	tags := r.FormValue("tags")
 	gallery.Title = r.FormValue("title")
+	gallery.Description = r.FormValue("description")
+	gallery.Public = r.FormValue("public") == "true"
+
+	var v validate.Validator
+	v.Field("title", gallery.Title, validate.Required(), validate.MaxLength(MaxTitleLength))
+	v.Field("description", gallery.Description, validate.MaxLength(MaxDescriptionLength))
+	v.Field("tags", tags, validate.MaxItems(models.MaxTags))
+	if !v.Valid() {
+		// Keep every tag that was typed, rather than only the ones
+		// ParseTags would have kept, so that the user can choose which to
+		// remove.
+		gallery.Tags = nil
+		for _, tag := range strings.Split(tags, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				gallery.Tags = append(gallery.Tags, tag)
+			}
+		}
+		g.renderEdit(w, r, gallery, nil, nil, v.Err())
+		return
+	}
+
+	gallery.Tags = models.ParseTags(tags)
 	err = g.GalleryService.Update(gallery)
 	if err != nil {
-		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
+	g.Flash.Add(w, r, flash.Success, localize(r, "Gallery saved."))
+
 	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
-	http.Redirect(w, r, editPath, http.StatusFound)
+	urls.Redirect(w, r, editPath, http.StatusFound)
 }
 
 func (g Galleries) Index(w http.ResponseWriter, r *http.Request) {
 	type Gallery struct {
-		ID    int
-		Title string
+		ID           int
+		Title        string
+		CoverEscaped string
+		CreatedAt    time.Time
+		UpdatedAt    time.Time
+		Role         string
+		CanEdit      bool
+		IsOwner      bool
 	}
 
 	var data struct {
 		Galleries []Gallery
+		Sort      string
+		Sorts     []struct {
+			Value string
+			Label string
+		}
+		PrevURL string
+		NextURL string
+	}
+	data.Sort = r.FormValue("sort")
+	if data.Sort == "" {
+		data.Sort = models.DefaultGallerySort
+	}
+	data.Sorts = []struct {
+		Value string
+		Label string
+	}{
+		{"-created", localize(r, "Newest first")},
+		{"created", localize(r, "Oldest first")},
+		{"-updated", localize(r, "Recently updated")},
+		{"title", localize(r, "Title (A-Z)")},
+		{"-title", localize(r, "Title (Z-A)")},
 	}
 
 	user := context.User(r.Context())
-	galleries, err := g.GalleryService.ByUserID(user.ID)
+	page, err := g.GalleryService.ByUserID(user.ID, data.Sort, models.PageRequest{
+		Cursor: r.FormValue("cursor"),
+	})
 	if err != nil {
-		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		if errors.Is(err, models.ErrInvalidSort) || errors.Is(err, models.ErrInvalidCursor) {
+			g.Errors.Render(w, r, http.StatusBadRequest, err)
+			return
+		}
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
-	for _, gallery := range galleries {
+	for _, gallery := range page.Items {
 		data.Galleries = append(data.Galleries, Gallery{
-			ID:    gallery.ID,
-			Title: gallery.Title,
+			ID:           gallery.ID,
+			Title:        gallery.Title,
+			CoverEscaped: url.PathEscape(gallery.Cover),
+			CreatedAt:    gallery.CreatedAt,
+			UpdatedAt:    gallery.UpdatedAt,
+			Role:         roleLabel(r, gallery.Role),
+			CanEdit:      gallery.Role.Can(models.RoleEditor),
+			IsOwner:      gallery.Role == models.RoleOwner,
 		})
 	}
 
+	pageURL := func(cursor string) string {
+		vals := url.Values{
+			"sort":   {data.Sort},
+			"cursor": {cursor},
+		}
+		return urls.Path(r, "/galleries?"+vals.Encode())
+	}
+	if page.PrevCursor != "" {
+		data.PrevURL = pageURL(page.PrevCursor)
+	}
+	if page.NextCursor != "" {
+		data.NextURL = pageURL(page.NextCursor)
+	}
+
 	g.Templates.Index.Execute(w, r, data)
 }
 
+func (g Galleries) Search(w http.ResponseWriter, r *http.Request) {
+	type Gallery struct {
+		ID           int
+		Title        string
+		Excerpt      string
+		Tags         []string
+		CoverEscaped string
+	}
+
+	var data struct {
+		Query     string
+		Tag       string
+		Galleries []Gallery
+		PrevURL   string
+		NextURL   string
+	}
+	data.Query = r.FormValue("q")
+	data.Tag = r.FormValue("tag")
+
+	page, _ := strconv.Atoi(r.FormValue("page"))
+	result, err := g.GalleryService.Search(models.GallerySearch{
+		Query: data.Query,
+		Tag:   data.Tag,
+		Page:  page,
+	})
+	if err != nil {
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	for _, gallery := range result.Galleries {
+		data.Galleries = append(data.Galleries, Gallery{
+			ID:           gallery.ID,
+			Title:        gallery.Title,
+			Excerpt:      markdown.Excerpt(gallery.Description, 200),
+			Tags:         gallery.Tags,
+			CoverEscaped: url.PathEscape(gallery.Cover),
+		})
+	}
+
+	pageURL := func(page int) string {
+		vals := url.Values{}
+		if data.Query != "" {
+			vals.Set("q", data.Query)
+		}
+		if data.Tag != "" {
+			vals.Set("tag", data.Tag)
+		}
+		vals.Set("page", strconv.Itoa(page))
+		return urls.Path(r, "/galleries/search?"+vals.Encode())
+	}
+	if result.Page > 1 {
+		data.PrevURL = pageURL(result.Page - 1)
+	}
+	if result.HasNext {
+		data.NextURL = pageURL(result.Page + 1)
+	}
+
+	g.Templates.Search.Execute(w, r, data)
+}
+
 func (g Galleries) Delete(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r, userMustOwnGallery)
+	gallery, err := g.galleryByID(w, r, g.userMustHaveRole(models.RoleOwner))
 	if err != nil {
 		return
 	}
 
 	err = g.GalleryService.Delete(gallery.ID)
 	if err != nil {
-		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
-	http.Redirect(w, r, "/galleries", http.StatusFound)
+	g.Flash.Add(w, r, flash.Success, localize(r, "Gallery deleted."))
+
+	urls.Redirect(w, r, "/galleries", http.StatusFound)
 }
 
 func (g Galleries) Image(w http.ResponseWriter, r *http.Request) {
-	filename := g.filename(w, r)
-	galleryID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	gallery, err := g.galleryByID(w, r)
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusNotFound)
 		return
 	}
 
-	image, err := g.GalleryService.Image(galleryID, filename)
+	link, err := g.visitorAccess(w, r, gallery)
+	if err != nil {
+		return
+	}
+
+	image, err := g.GalleryService.Image(gallery.ID, g.filename(w, r))
 	if err != nil {
 		if errors.Is(err, models.ErrNotFound) {
-			http.Error(w, "Image not found", http.StatusNotFound)
+			g.Errors.Message(w, r, http.StatusNotFound, "Image not found")
 			return
 		}
-		fmt.Println(err)
-		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
+	if r.FormValue("size") != "thumb" {
+		g.Views.ImageView(image.ID, visitorKey(r))
+	}
+
+	// Thumbnails are made in the background, so the original is served
+	// until one exists, unless the visitor's share link doesn't allow
+	// downloading originals.
+	originals := link == nil || link.AllowDownloads
+	if r.FormValue("size") == "thumb" || !originals {
+		_, err = os.Stat(image.ThumbnailPath)
+		if err == nil {
+			http.ServeFile(w, r, image.ThumbnailPath)
+			return
+		}
+		if !originals {
+			g.Errors.Message(w, r, http.StatusNotFound, "Image not found")
+			return
+		}
+	}
+
+	// Thumbnails that aren't made yet don't count as downloads.
+	if link != nil && r.FormValue("size") != "thumb" {
+		err = g.ShareLinkService.CountDownload(link.ID)
+		if err != nil {
+			g.Errors.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	disposition := mime.FormatMediaType("inline", map[string]string{
+		"filename": image.Name,
+	})
+	if disposition != "" {
+		w.Header().Set("Content-Disposition", disposition)
+	}
 	http.ServeFile(w, r, image.Path)
 }
 
 func (g Galleries) UploadImage(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r, userMustOwnGallery)
+	gallery, err := g.galleryByID(w, r, g.userMustHaveRole(models.RoleEditor))
 	if err != nil {
 		return
 	}
 
-	err = r.ParseMultipartForm(5 << 20) // 5mb
+	user := context.User(r.Context())
+	var results []UploadResult
+	err = eachUpload(w, r, "images", g.UploadLimits.requestSize(), func(filename string, contents io.Reader) error {
+		result, err := g.storeUpload(gallery.ID, user.ID, filename, contents)
+		if err != nil {
+			return err
+		}
+		results = append(results, result)
+		return nil
+	})
+
+	status := http.StatusOK
 	if err != nil {
-		http.Error(w, "Something went wrong", http.StatusInternalServerError)
-		return
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			status = http.StatusRequestEntityTooLarge
+			err = errors.Public(err, fmt.Sprintf("The upload exceeded the %s limit per request. Files after the limit were not saved.", formatBytes(g.UploadLimits.requestSize())))
+		} else {
+			logf(r, "uploading to gallery %d: %v", gallery.ID, err)
+			status = http.StatusBadRequest
+		}
 	}
 
-	fileHeaders := r.MultipartForm.File["images"]
-	for _, fileHeader := range fileHeaders {
-		file, err := fileHeader.Open()
+	g.uploadResponse(w, r, gallery, results, status, err)
+}
+
+// uploadResponse reports the outcome of an upload as JSON for scripts, or by
+// re-rendering the edit page when any file failed.
+func (g Galleries) uploadResponse(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, results []UploadResult, status int, err error) {
+	if wantsJSON(r) {
+		var resp struct {
+			Results []UploadResult `json:"results"`
+			Error   string         `json:"error,omitempty"`
+		}
+		resp.Results = results
 		if err != nil {
-			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+			resp.Error = "The upload could not be completed."
+			var pubErr interface{ Public() string }
+			if errors.As(err, &pubErr) {
+				resp.Error = pubErr.Public()
+			}
+		}
+		writeJSON(w, status, resp)
+		return
+	}
+
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	failed := len(errs) > 0
+	for _, result := range results {
+		failed = failed || !result.OK()
+	}
+
+	if failed {
+		g.renderEdit(w, r, gallery, results, nil, errs...)
+		return
+	}
+
+	if len(results) > 0 {
+		g.Flash.Add(w, r, flash.Success, localize(r, "Added %d images.", len(results)))
+	}
+
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	urls.Redirect(w, r, editPath, http.StatusFound)
+}
+
+func (g Galleries) UpdateImage(w http.ResponseWriter, r *http.Request) {
+	filename := g.filename(w, r)
+	gallery, err := g.galleryByID(w, r, g.userMustHaveRole(models.RoleEditor))
+	if err != nil {
+		return
+	}
+
+	image, err := g.GalleryService.ImageByFilename(gallery.ID, filename)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			g.Errors.Message(w, r, http.StatusNotFound, "Image not found")
 			return
 		}
-		defer file.Close()
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
 
-		err = g.GalleryService.CreateImage(gallery.ID, fileHeader.Filename, file)
-		if err != nil {
-			var fileErr models.FileError
-			if errors.As(err, &fileErr) {
-				msg := fmt.Sprintf("%v has an invalid content type or extension. Only png, gif, jpeg and jpg files can be uploaded.", fileHeader.Filename)
-				http.Error(w, msg, http.StatusBadRequest)
-				return
-			}
-			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+	image.Name = r.FormValue("name")
+	image.Caption = r.FormValue("caption")
+	image.AltText = r.FormValue("alt_text")
+
+	var v validate.Validator
+	v.Field(imageField(image.Filename, "name"), image.Name, validate.Required(), validate.MaxLength(MaxImageFieldLength))
+	v.Field(imageField(image.Filename, "caption"), image.Caption, validate.MaxLength(MaxImageFieldLength))
+	v.Field(imageField(image.Filename, "alt_text"), image.AltText, validate.MaxLength(MaxImageFieldLength))
+	if !v.Valid() {
+		g.renderEdit(w, r, gallery, nil, &image, v.Err())
+		return
+	}
+
+	err = g.GalleryService.UpdateImage(&image)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidName) {
+			v.Add(imageField(image.Filename, "name"), "The name can't be empty.")
+			g.renderEdit(w, r, gallery, nil, &image, v.Err())
 			return
 		}
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
 	}
 
+	g.Flash.Add(w, r, flash.Success, localize(r, "Image details saved."))
+
 	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
-	http.Redirect(w, r, editPath, http.StatusFound)
+	urls.Redirect(w, r, editPath, http.StatusFound)
+}
+
+// imageField names a field of one image's details form, as the edit page
+// shows a form for every image.
+func imageField(filename, field string) string {
+	return "image." + filename + "." + field
+}
+
+func (g Galleries) ReorderImages(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userMustHaveRole(models.RoleEditor))
+	if err != nil {
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		g.Errors.Message(w, r, http.StatusBadRequest, "Invalid form")
+		return
+	}
+
+	err = g.GalleryService.ReorderImages(gallery.ID, r.PostForm["order"])
+	if err != nil {
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	urls.Redirect(w, r, editPath, http.StatusFound)
+}
+
+func (g Galleries) SetCover(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userMustHaveRole(models.RoleEditor))
+	if err != nil {
+		return
+	}
+
+	err = g.GalleryService.SetCover(gallery.ID, r.FormValue("filename"))
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			g.Errors.Message(w, r, http.StatusNotFound, "Image not found")
+			return
+		}
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	g.Flash.Add(w, r, flash.Success, localize(r, "Cover image updated."))
+
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	urls.Redirect(w, r, editPath, http.StatusFound)
 }
 
 func (g Galleries) DeleteImage(w http.ResponseWriter, r *http.Request) {
 	filename := g.filename(w, r)
-	gallery, err := g.galleryByID(w, r, userMustOwnGallery)
+	gallery, err := g.galleryByID(w, r, g.userMustHaveRole(models.RoleEditor))
 	if err != nil {
 		return
 	}
 
 	err = g.GalleryService.DeleteImage(gallery.ID, filename)
 	if err != nil {
-		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
+	g.Flash.Add(w, r, flash.Success, localize(r, "Image deleted."))
+
 	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
-	http.Redirect(w, r, editPath, http.StatusFound)
+	urls.Redirect(w, r, editPath, http.StatusFound)
 }
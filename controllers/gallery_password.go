@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IrakliGiorgadze/go-web-app/context"
+	"github.com/IrakliGiorgadze/go-web-app/errors"
+	"github.com/IrakliGiorgadze/go-web-app/flash"
+	"github.com/IrakliGiorgadze/go-web-app/models"
+	"github.com/IrakliGiorgadze/go-web-app/urls"
+	"github.com/IrakliGiorgadze/go-web-app/validate"
+)
+
+// GalleryAccessDuration is how long a visitor who entered a gallery's
+// password can see it before being asked again.
+const GalleryAccessDuration = 30 * 24 * time.Hour
+
+// SetPassword protects the gallery with a password, or removes it when the
+// remove button was used.
+func (g Galleries) SetPassword(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userMustHaveRole(models.RoleOwner))
+	if err != nil {
+		return
+	}
+
+	password := r.FormValue("password")
+	remove := r.FormValue("remove") == "true"
+	if remove {
+		password = ""
+	}
+
+	var v validate.Validator
+	if !remove {
+		v.Field("password", password, validate.Required(), validate.MaxBytes(MaxPasswordBytes))
+	} else {
+		withheld, err := g.withholdsDownloads(gallery)
+		if err != nil {
+			g.Errors.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if withheld {
+			v.Add("password", "Revoke the share links without downloads before removing the password.")
+		}
+	}
+	if !v.Valid() {
+		g.renderEdit(w, r, gallery, nil, nil, v.Err())
+		return
+	}
+
+	err = g.GalleryService.SetPassword(gallery.ID, password)
+	if err != nil {
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if remove {
+		g.Flash.Add(w, r, flash.Success, localize(r, "Password removed. Anyone with the link can see the gallery."))
+	} else {
+		g.Flash.Add(w, r, flash.Success, localize(r, "Password set. Visitors need it to see the gallery."))
+	}
+
+	urls.Redirect(w, r, fmt.Sprintf("/galleries/%d/edit", gallery.ID), http.StatusFound)
+}
+
+// withholdsDownloads reports whether the gallery has working share links
+// that don't allow downloads. They rely on the password, since without one
+// the originals are open to everyone.
+func (g Galleries) withholdsDownloads(gallery *models.Gallery) (bool, error) {
+	links, err := g.ShareLinkService.ByGallery(gallery.ID)
+	if err != nil {
+		return false, err
+	}
+	for _, link := range links {
+		if link.Active() && !link.AllowDownloads {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Unlock checks the password a visitor entered and, if it is right, lets
+// them see the gallery without asking again.
+func (g Galleries) Unlock(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+
+	returnTo := galleryReturnPath(r, gallery)
+
+	if gallery.PasswordHash == "" {
+		urls.Redirect(w, r, returnTo, http.StatusFound)
+		return
+	}
+
+	err = g.GalleryService.CheckPassword(gallery, clientAddr(r), r.FormValue("password"))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrWrongGalleryPassword):
+			var v validate.Validator
+			v.Add("password", "That password is not right.")
+			g.renderUnlock(w, r, gallery, returnTo, http.StatusForbidden, v.Err())
+		case errors.Is(err, models.ErrTooManyAttempts):
+			err = errors.Public(err, "Too many wrong passwords. Try again in a few minutes.")
+			g.renderUnlock(w, r, gallery, returnTo, http.StatusTooManyRequests, err)
+		default:
+			g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	expires := time.Now().Add(GalleryAccessDuration)
+	cookie := newCookie(r, galleryAccessCookie(gallery.ID), g.signAccess(gallery, expires.Unix()))
+	cookie.Expires = expires
+	http.SetCookie(w, cookie)
+
+	urls.Redirect(w, r, returnTo, http.StatusFound)
+}
+
+// visitorMustKnowPassword asks for the gallery's password, if it has one,
+// unless the visitor has already entered it.
+func (g Galleries) visitorMustKnowPassword(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) error {
+	if gallery.PasswordHash == "" || g.hasAccess(r, gallery) {
+		return nil
+	}
+
+	g.renderUnlock(w, r, gallery, r.URL.RequestURI(), http.StatusForbidden)
+	return errors.New("gallery needs a password")
+}
+
+func (g Galleries) renderUnlock(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, returnTo string, status int, errs ...error) {
+	var data struct {
+		ID     int
+		Title  string
+		Return string
+	}
+	data.ID = gallery.ID
+	data.Title = gallery.Title
+	data.Return = returnTo
+
+	executeStatus(w, r, g.Templates.Unlock, status, data, errs...)
+}
+
+// hasAccess reports whether the request carries a cookie from Unlock for
+// the gallery's current password.
+func (g Galleries) hasAccess(r *http.Request, gallery *models.Gallery) bool {
+	value, err := readCookie(r, galleryAccessCookie(gallery.ID))
+	if err != nil {
+		return false
+	}
+
+	expiresStr, _, ok := strings.Cut(value, ".")
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+
+	return hmac.Equal([]byte(value), []byte(g.signAccess(gallery, expires)))
+}
+
+// signAccess makes the value of a gallery's access cookie. The signature
+// covers the password hash, so changing the password locks out everyone
+// who entered the old one.
+func (g Galleries) signAccess(gallery *models.Gallery, expires int64) string {
+	payload := strconv.FormatInt(expires, 10)
+
+	mac := hmac.New(sha256.New, g.AccessKey)
+	fmt.Fprintf(mac, "%d.%s.%s", gallery.ID, payload, gallery.PasswordHash)
+
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func galleryAccessCookie(galleryID int) string {
+	return CookieGalleryAccess + strconv.Itoa(galleryID)
+}
+
+// clientAddr identifies the visitor for limiting how many passwords they can
+// try, and the like. It is the address found by
+// BaseURLMiddleware.SetClientAddr, which looks past trusted proxies.
+func clientAddr(r *http.Request) string {
+	if addr := context.ClientAddr(r.Context()); addr != "" {
+		return addr
+	}
+
+	return remoteHost(r.RemoteAddr)
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/IrakliGiorgadze/go-web-app/context"
 	"github.com/IrakliGiorgadze/go-web-app/models"
@@ -13,6 +14,18 @@ import (
 	"github.com/go-chi/chi/v5"
 )
 
+// galleryReturnPath is the page of the gallery that a form asked to return
+// to, or the gallery itself.
+func galleryReturnPath(r *http.Request, gallery *models.Gallery) string {
+	galleryPath := fmt.Sprintf("/galleries/%d", gallery.ID)
+	p := r.FormValue("return")
+	if p != galleryPath && !strings.HasPrefix(p, galleryPath+"/") && !strings.HasPrefix(p, galleryPath+"?") {
+		return galleryPath
+	}
+
+	return returnPath(p)
+}
+
 func (g Galleries) filename(w http.ResponseWriter, r *http.Request) string {
 	filename := chi.URLParam(r, "filename")
 	filename = filepath.Base(filename)
@@ -25,17 +38,17 @@ type galleryOpt func(http.ResponseWriter, *http.Request, *models.Gallery) error
 func (g Galleries) galleryByID(w http.ResponseWriter, r *http.Request, opts ...galleryOpt) (*models.Gallery, error) {
 	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusNotFound)
+		g.Errors.Message(w, r, http.StatusNotFound, "Invalid ID")
 		return nil, err
 	}
 
 	gallery, err := g.GalleryService.ByID(id)
 	if err != nil {
 		if errors.Is(err, models.ErrNotFound) {
-			http.Error(w, "Gallery not found", http.StatusNotFound)
+			g.Errors.Message(w, r, http.StatusNotFound, "Gallery not found")
 			return nil, err
 		}
-		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
 		return nil, err
 	}
 
@@ -49,12 +62,26 @@ func (g Galleries) galleryByID(w http.ResponseWriter, r *http.Request, opts ...g
 	return gallery, nil
 }
 
-func userMustOwnGallery(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) error {
-	user := context.User(r.Context())
-	if gallery.UserID != user.ID {
-		http.Error(w, "You are not authorized to edit this gallery", http.StatusForbidden)
-		return fmt.Errorf("user does not have access to this gallery")
-	}
+// userMustHaveRole only lets through members of the gallery whose role
+// allows at least min.
+func (g Galleries) userMustHaveRole(min models.Role) galleryOpt {
+	return func(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) error {
+		user := context.User(r.Context())
+		role, err := g.GalleryService.Role(gallery.ID, user.ID)
+		if err != nil {
+			g.Errors.Render(w, r, http.StatusInternalServerError, err)
+			return err
+		}
 
-	return nil
+		if !role.Can(min) {
+			if min == models.RoleOwner {
+				g.Errors.Message(w, r, http.StatusForbidden, "Only the owner of this gallery can do that.")
+			} else {
+				g.Errors.Message(w, r, http.StatusForbidden, "You are not authorized to edit this gallery")
+			}
+			return fmt.Errorf("user does not have the %s role in this gallery", min)
+		}
+
+		return nil
+	}
 }
@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/IrakliGiorgadze/go-web-app/context"
+	"github.com/IrakliGiorgadze/go-web-app/errors"
+	"github.com/IrakliGiorgadze/go-web-app/models"
+)
+
+// ImportZip adds every image in an uploaded ZIP archive to the gallery and
+// reports the entries that were skipped.
+func (g Galleries) ImportZip(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userMustHaveRole(models.RoleEditor))
+	if err != nil {
+		return
+	}
+
+	user := context.User(r.Context())
+	limits := g.UploadLimits.Zip
+	if limits.MaxEntrySize <= 0 {
+		limits.MaxEntrySize = g.UploadLimits.fileSize()
+	}
+
+	var results []UploadResult
+	var received bool
+	err = eachUpload(w, r, "archive", g.UploadLimits.archiveSize(), func(filename string, contents io.Reader) error {
+		if received {
+			return nil
+		}
+		received = true
+
+		tmp, err := os.CreateTemp("", "archive-*.zip")
+		if err != nil {
+			return fmt.Errorf("import zip: %w", err)
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		size, err := io.Copy(tmp, contents)
+		if err != nil {
+			return fmt.Errorf("import zip: %w", err)
+		}
+
+		entries, err := g.GalleryService.ImportZip(gallery.ID, user.ID, tmp, size, limits)
+		for _, entry := range entries {
+			results = append(results, UploadResult{
+				Filename: entry.Name,
+				Error:    entry.Skipped,
+			})
+		}
+		return err
+	})
+	if err == nil && !received {
+		err = errors.Public(fmt.Errorf("import zip: no archive"), "Please choose a ZIP file to import.")
+	}
+
+	status := http.StatusOK
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesErr):
+			status = http.StatusRequestEntityTooLarge
+			err = errors.Public(err, fmt.Sprintf("The archive is larger than the %s limit.", formatBytes(g.UploadLimits.archiveSize())))
+		case errors.Is(err, zip.ErrFormat):
+			status = http.StatusBadRequest
+			err = errors.Public(err, "The file is not a valid ZIP archive.")
+		case errors.Is(err, models.ErrTooManyEntries):
+			status = http.StatusBadRequest
+			err = errors.Public(err, "The archive contains too many files.")
+		case errors.Is(err, models.ErrArchiveTooLarge):
+			status = http.StatusRequestEntityTooLarge
+			err = errors.Public(err, "The archive expands to more data than we accept. Files after the limit were not imported.")
+		default:
+			var pubErr interface{ Public() string }
+			if !errors.As(err, &pubErr) {
+				logf(r, "importing into gallery %d: %v", gallery.ID, err)
+			}
+			status = http.StatusBadRequest
+		}
+	}
+
+	g.uploadResponse(w, r, gallery, results, status, err)
+}
@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/IrakliGiorgadze/go-web-app/context"
+	"github.com/IrakliGiorgadze/go-web-app/i18n"
+	"github.com/IrakliGiorgadze/go-web-app/models"
+	"github.com/IrakliGiorgadze/go-web-app/urls"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// LocaleMiddleware picks the language to answer in: the signed in user's
+// choice, then the choice of a signed out visitor kept in a cookie, then
+// the browser's Accept-Language header. It must run after
+// UserMiddleware.SetUser.
+type LocaleMiddleware struct {
+	Catalog *i18n.Catalog
+}
+
+func (lmw LocaleMiddleware) SetLocale(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var prefs []string
+		if user := context.User(r.Context()); user != nil {
+			prefs = append(prefs, user.Locale)
+		}
+		if locale, err := readCookie(r, CookieLocale); err == nil {
+			prefs = append(prefs, locale)
+		}
+		prefs = append(prefs, r.Header.Get("Accept-Language"))
+
+		localizer := lmw.Catalog.Localizer(lmw.Catalog.Match(prefs...))
+
+		ctx := r.Context()
+		ctx = context.WithLocalizer(ctx, localizer)
+		r = r.WithContext(ctx)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Locales lets visitors choose the language of the site.
+type Locales struct {
+	Catalog     *i18n.Catalog
+	UserService *models.UserService
+	Errors      ErrorPages
+	// Routes, when set, limits the pages visitors return to after choosing
+	// a language to ones that exist.
+	Routes chi.Routes
+}
+
+func (l Locales) Update(w http.ResponseWriter, r *http.Request) {
+	locale := r.FormValue("locale")
+	if !l.supported(locale) {
+		l.Errors.Message(w, r, http.StatusBadRequest, "That language isn't available.")
+		return
+	}
+
+	if user := context.User(r.Context()); user != nil {
+		err := l.UserService.UpdateLocale(user.ID, locale)
+		if err != nil {
+			l.Errors.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	cookie := newCookie(r, CookieLocale, locale)
+	cookie.MaxAge = 365 * 24 * 60 * 60
+	http.SetCookie(w, cookie)
+
+	urls.Redirect(w, r, l.returnPath(r.FormValue("return")), http.StatusFound)
+}
+
+func (l Locales) returnPath(p string) string {
+	p = returnPath(p)
+	if l.Routes == nil {
+		return p
+	}
+
+	u, err := url.Parse(p)
+	if err != nil || !l.Routes.Match(chi.NewRouteContext(), http.MethodGet, u.Path) {
+		return "/"
+	}
+
+	return p
+}
+
+func (l Locales) supported(locale string) bool {
+	for _, code := range l.Catalog.Locales() {
+		if code == locale {
+			return true
+		}
+	}
+
+	return false
+}
+
+// returnPath only allows paths on this site, so that the form can't be
+// used to send visitors elsewhere. Browsers ignore tabs and newlines in
+// URLs and treat backslashes as slashes, so "/\t/example.com" would
+// otherwise leave the site.
+func returnPath(p string) string {
+	if !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") || strings.Contains(p, "\\") {
+		return "/"
+	}
+	if strings.IndexFunc(p, isControl) >= 0 {
+		return "/"
+	}
+
+	u, err := url.Parse(p)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil || strings.HasPrefix(u.Path, "//") {
+		return "/"
+	}
+
+	return p
+}
+
+func isControl(r rune) bool {
+	return r < 0x20 || r == 0x7f
+}
+
+// localize translates a message into the language of the request.
+func localize(r *http.Request, key string, args ...any) string {
+	return context.Localizer(r.Context()).T(key, args...)
+}
+
+// locale is the language emails sent during the request are written in.
+func locale(r *http.Request) string {
+	if localizer := context.Localizer(r.Context()); localizer != nil {
+		return localizer.Locale()
+	}
+
+	return r.Header.Get("Accept-Language")
+}
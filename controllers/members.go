@@ -0,0 +1,314 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/IrakliGiorgadze/go-web-app/context"
+	"github.com/IrakliGiorgadze/go-web-app/errors"
+	"github.com/IrakliGiorgadze/go-web-app/flash"
+	"github.com/IrakliGiorgadze/go-web-app/models"
+	"github.com/IrakliGiorgadze/go-web-app/urls"
+	"github.com/IrakliGiorgadze/go-web-app/validate"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Members shows the owner who can work on a gallery, and lets them invite
+// more people.
+func (g Galleries) Members(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userMustHaveRole(models.RoleOwner))
+	if err != nil {
+		return
+	}
+
+	g.renderMembers(w, r, gallery, "", string(models.RoleEditor))
+}
+
+func (g Galleries) renderMembers(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, email, role string, errs ...error) {
+	type Member struct {
+		UserID  int
+		Name    string
+		Email   string
+		Role    string
+		Label   string
+		IsOwner bool
+	}
+
+	type Invitation struct {
+		ID        int
+		Email     string
+		Label     string
+		ExpiresAt time.Time
+	}
+
+	var data struct {
+		ID          int
+		Title       string
+		Members     []Member
+		Invitations []Invitation
+		Roles       []struct {
+			Value string
+			Label string
+		}
+		Email string
+		Role  string
+	}
+	data.ID = gallery.ID
+	data.Title = gallery.Title
+	data.Email = email
+	data.Role = role
+	for _, role := range []models.Role{models.RoleEditor, models.RoleViewer} {
+		data.Roles = append(data.Roles, struct {
+			Value string
+			Label string
+		}{string(role), roleLabel(r, role)})
+	}
+
+	members, err := g.GalleryService.Members(gallery.ID)
+	if err != nil {
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	for _, member := range members {
+		data.Members = append(data.Members, Member{
+			UserID:  member.UserID,
+			Name:    member.Name(),
+			Email:   member.Email,
+			Role:    string(member.Role),
+			Label:   roleLabel(r, member.Role),
+			IsOwner: member.Role == models.RoleOwner,
+		})
+	}
+
+	invitations, err := g.InvitationService.ByGallery(gallery.ID)
+	if err != nil {
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	for _, invitation := range invitations {
+		data.Invitations = append(data.Invitations, Invitation{
+			ID:        invitation.ID,
+			Email:     invitation.Email,
+			Label:     roleLabel(r, invitation.Role),
+			ExpiresAt: invitation.ExpiresAt,
+		})
+	}
+
+	g.Templates.Members.Execute(w, r, data, errs...)
+}
+
+// Invite emails a link that makes the recipient a member of the gallery
+// once they sign in and accept it.
+func (g Galleries) Invite(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userMustHaveRole(models.RoleOwner))
+	if err != nil {
+		return
+	}
+
+	email := r.FormValue("email")
+	role := models.Role(r.FormValue("role"))
+
+	var v validate.Validator
+	v.Field("email", email, validate.Required(), validate.Email(), validate.MaxLength(MaxEmailLength))
+	if !role.Invitable() {
+		v.Add("role", "Choose whether they can edit or only view the gallery.")
+	}
+	if !v.Valid() {
+		g.renderMembers(w, r, gallery, email, string(role), v.Err())
+		return
+	}
+
+	user := context.User(r.Context())
+	invitation, err := g.InvitationService.Create(gallery.ID, user.ID, email, role)
+	if err != nil {
+		if errors.Is(err, models.ErrAlreadyMember) {
+			v.Add("email", "They are already a member of this gallery.")
+			g.renderMembers(w, r, gallery, email, string(role), v.Err())
+			return
+		}
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	inviterName := user.Name()
+	if inviterName == "" {
+		inviterName = user.Email
+	}
+	err = g.EmailService.GalleryInvitation(
+		invitation.Email,
+		locale(r),
+		inviterName,
+		gallery.Title,
+		invitation.Role,
+		urls.Absolute(r, "/invitations/"+invitation.Token),
+	)
+	if err != nil {
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	g.Flash.Add(w, r, flash.Success, localize(r, "Invitation sent to %s.", invitation.Email))
+	urls.Redirect(w, r, fmt.Sprintf("/galleries/%d/members", gallery.ID), http.StatusFound)
+}
+
+// UpdateMember changes the role of a member other than the owner.
+func (g Galleries) UpdateMember(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userMustHaveRole(models.RoleOwner))
+	if err != nil {
+		return
+	}
+
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		g.Errors.Message(w, r, http.StatusNotFound, "Member not found")
+		return
+	}
+
+	err = g.GalleryService.SetMemberRole(gallery.ID, userID, models.Role(r.FormValue("role")))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidRole):
+			g.Errors.Message(w, r, http.StatusBadRequest, "Choose whether they can edit or only view the gallery.")
+		case errors.Is(err, models.ErrNotFound):
+			g.Errors.Message(w, r, http.StatusNotFound, "Member not found")
+		default:
+			g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	g.Flash.Add(w, r, flash.Success, localize(r, "Member updated."))
+	urls.Redirect(w, r, fmt.Sprintf("/galleries/%d/members", gallery.ID), http.StatusFound)
+}
+
+// RemoveMember lets the owner remove a member, and members remove
+// themselves.
+func (g Galleries) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userMustHaveRole(models.RoleViewer))
+	if err != nil {
+		return
+	}
+
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		g.Errors.Message(w, r, http.StatusNotFound, "Member not found")
+		return
+	}
+
+	user := context.User(r.Context())
+	leaving := userID == user.ID
+	if !leaving {
+		err = g.userMustHaveRole(models.RoleOwner)(w, r, gallery)
+		if err != nil {
+			return
+		}
+	}
+
+	err = g.GalleryService.RemoveMember(gallery.ID, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			g.Errors.Message(w, r, http.StatusNotFound, "Member not found")
+			return
+		}
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if leaving {
+		g.Flash.Add(w, r, flash.Info, localize(r, "You left %s.", gallery.Title))
+		urls.Redirect(w, r, "/galleries", http.StatusFound)
+		return
+	}
+
+	g.Flash.Add(w, r, flash.Success, localize(r, "Member removed."))
+	urls.Redirect(w, r, fmt.Sprintf("/galleries/%d/members", gallery.ID), http.StatusFound)
+}
+
+func (g Galleries) RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userMustHaveRole(models.RoleOwner))
+	if err != nil {
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "invitationID"))
+	if err != nil {
+		g.Errors.Message(w, r, http.StatusNotFound, "Invitation not found")
+		return
+	}
+
+	err = g.InvitationService.Revoke(gallery.ID, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			g.Errors.Message(w, r, http.StatusNotFound, "Invitation not found")
+			return
+		}
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	g.Flash.Add(w, r, flash.Success, localize(r, "Invitation cancelled."))
+	urls.Redirect(w, r, fmt.Sprintf("/galleries/%d/members", gallery.ID), http.StatusFound)
+}
+
+// Invitation shows the invitation an emailed link points at. Anyone can
+// see it; accepting it needs an account.
+func (g Galleries) Invitation(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Token        string
+		GalleryTitle string
+		InviterName  string
+		Role         string
+		SignedIn     bool
+	}
+	data.Token = chi.URLParam(r, "token")
+	data.SignedIn = context.User(r.Context()) != nil
+
+	invitation, err := g.InvitationService.ByToken(data.Token)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) || errors.Is(err, models.ErrTokenExpired) {
+			err = errors.Public(err, "That invitation link is invalid or has expired. Ask for a new one.")
+			executeStatus(w, r, g.Templates.Invitation, http.StatusNotFound, data, err)
+			return
+		}
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	data.GalleryTitle = invitation.GalleryTitle
+	data.InviterName = invitation.InviterName
+	data.Role = roleLabel(r, invitation.Role)
+
+	g.Templates.Invitation.Execute(w, r, data)
+}
+
+func (g Galleries) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	invitation, err := g.InvitationService.Accept(chi.URLParam(r, "token"), user.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) || errors.Is(err, models.ErrTokenExpired) {
+			g.Errors.Message(w, r, http.StatusNotFound, "That invitation link is invalid or has expired. Ask for a new one.")
+			return
+		}
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	g.Flash.Add(w, r, flash.Success, localize(r, "You're now a member of %s.", invitation.GalleryTitle))
+	urls.Redirect(w, r, "/galleries", http.StatusFound)
+}
+
+func roleLabel(r *http.Request, role models.Role) string {
+	switch role {
+	case models.RoleOwner:
+		return localize(r, "Owner")
+	case models.RoleEditor:
+		return localize(r, "Editor")
+	case models.RoleViewer:
+		return localize(r, "Viewer")
+	}
+
+	return string(role)
+}
@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/IrakliGiorgadze/go-web-app/context"
+	"github.com/IrakliGiorgadze/go-web-app/errors"
+	"github.com/IrakliGiorgadze/go-web-app/markdown"
+	"github.com/IrakliGiorgadze/go-web-app/models"
+	"github.com/IrakliGiorgadze/go-web-app/urls"
+)
+
+// Explore lists the public galleries that have been popular lately.
+func (g Galleries) Explore(w http.ResponseWriter, r *http.Request) {
+	type Gallery struct {
+		ID           int
+		Title        string
+		Excerpt      string
+		CoverEscaped string
+		Views        int
+		Likes        int
+	}
+
+	var data struct {
+		Galleries []Gallery
+		PrevURL   string
+		NextURL   string
+	}
+
+	page, err := g.GalleryService.Popular(models.PageRequest{
+		Cursor: r.FormValue("cursor"),
+	})
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCursor) {
+			g.Errors.Render(w, r, http.StatusBadRequest, err)
+			return
+		}
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	for _, gallery := range page.Items {
+		data.Galleries = append(data.Galleries, Gallery{
+			ID:           gallery.ID,
+			Title:        gallery.Title,
+			Excerpt:      markdown.Excerpt(gallery.Description, 200),
+			CoverEscaped: url.PathEscape(gallery.Cover),
+			Views:        gallery.Views,
+			Likes:        gallery.Likes,
+		})
+	}
+
+	pageURL := func(cursor string) string {
+		vals := url.Values{
+			"cursor": {cursor},
+		}
+		return urls.Path(r, "/explore?"+vals.Encode())
+	}
+	if page.PrevCursor != "" {
+		data.PrevURL = pageURL(page.PrevCursor)
+	}
+	if page.NextCursor != "" {
+		data.NextURL = pageURL(page.NextCursor)
+	}
+
+	g.Templates.Explore.Execute(w, r, data)
+}
+
+// LikeGallery likes the gallery for the signed in user, or takes the like
+// back.
+func (g Galleries) LikeGallery(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+
+	_, err = g.visitorAccess(w, r, gallery)
+	if err != nil {
+		return
+	}
+
+	_, err = g.LikeService.ToggleGallery(gallery.ID, context.User(r.Context()).ID)
+	if err != nil {
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	urls.Redirect(w, r, galleryReturnPath(r, gallery), http.StatusFound)
+}
+
+// LikeImage likes one of the gallery's images, or takes the like back.
+func (g Galleries) LikeImage(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+
+	_, err = g.visitorAccess(w, r, gallery)
+	if err != nil {
+		return
+	}
+
+	image, err := g.GalleryService.ImageByFilename(gallery.ID, g.filename(w, r))
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			g.Errors.Message(w, r, http.StatusNotFound, "Image not found")
+			return
+		}
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	_, err = g.LikeService.ToggleImage(image.ID, context.User(r.Context()).ID)
+	if err != nil {
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	returnTo := galleryReturnPath(r, gallery)
+	if returnTo == fmt.Sprintf("/galleries/%d", gallery.ID) {
+		returnTo += "#image-" + strconv.Itoa(image.ID)
+	}
+	urls.Redirect(w, r, returnTo, http.StatusFound)
+}
+
+// visitorKey identifies the visitor when counting views: by their account
+// if they are signed in, and by their address otherwise.
+func visitorKey(r *http.Request) string {
+	if user := context.User(r.Context()); user != nil {
+		return "user:" + strconv.Itoa(user.ID)
+	}
+
+	return "addr:" + clientAddr(r)
+}
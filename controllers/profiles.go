@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/IrakliGiorgadze/go-web-app/errors"
+	"github.com/IrakliGiorgadze/go-web-app/markdown"
+	"github.com/IrakliGiorgadze/go-web-app/models"
+	"github.com/IrakliGiorgadze/go-web-app/urls"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Profiles shows users' public pages at /u/{username}.
+type Profiles struct {
+	Templates struct {
+		Show Template
+	}
+	UserService    *models.UserService
+	GalleryService *models.GalleryService
+	Errors         ErrorPages
+}
+
+func (p Profiles) Show(w http.ResponseWriter, r *http.Request) {
+	user, err := p.userByUsername(w, r)
+	if err != nil {
+		return
+	}
+
+	type Gallery struct {
+		ID           int
+		Title        string
+		Excerpt      string
+		CoverEscaped string
+	}
+
+	var data struct {
+		Username  string
+		Name      string
+		Bio       string
+		AvatarURL string
+		Galleries []Gallery
+		PrevURL   string
+		NextURL   string
+	}
+	data.Username = user.Username
+	data.Name = user.Name()
+	data.Bio = user.Bio
+	data.AvatarURL = avatarURL(r, user)
+
+	page, _ := strconv.Atoi(r.FormValue("page"))
+	result, err := p.GalleryService.Search(models.GallerySearch{
+		UserID: user.ID,
+		Page:   page,
+	})
+	if err != nil {
+		p.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	for _, gallery := range result.Galleries {
+		data.Galleries = append(data.Galleries, Gallery{
+			ID:           gallery.ID,
+			Title:        gallery.Title,
+			Excerpt:      markdown.Excerpt(gallery.Description, 200),
+			CoverEscaped: url.PathEscape(gallery.Cover),
+		})
+	}
+
+	pageURL := func(page int) string {
+		return urls.Path(r, profilePath(user)+"?page="+strconv.Itoa(page))
+	}
+	if result.Page > 1 {
+		data.PrevURL = pageURL(result.Page - 1)
+	}
+	if result.HasNext {
+		data.NextURL = pageURL(result.Page + 1)
+	}
+
+	p.Templates.Show.Execute(w, r, data)
+}
+
+func (p Profiles) Avatar(w http.ResponseWriter, r *http.Request) {
+	user, err := p.userByUsername(w, r)
+	if err != nil {
+		return
+	}
+
+	if user.Avatar == "" {
+		p.Errors.NotFound(w, r)
+		return
+	}
+
+	http.ServeFile(w, r, p.UserService.AvatarPath(user.Avatar))
+}
+
+// userByUsername looks up the user named in the URL. Old usernames and
+// ones in the wrong case are redirected to the user's current username, so
+// that links keep working after a user renames themselves.
+func (p Profiles) userByUsername(w http.ResponseWriter, r *http.Request) (*models.User, error) {
+	username := chi.URLParam(r, "username")
+	user, err := p.UserService.ByUsername(username)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			p.Errors.NotFound(w, r)
+			return nil, err
+		}
+		p.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return nil, err
+	}
+
+	if username != user.Username {
+		rest := strings.TrimPrefix(r.URL.Path, "/u/"+username)
+		if r.URL.RawQuery != "" {
+			rest += "?" + r.URL.RawQuery
+		}
+		urls.Redirect(w, r, profilePath(user)+rest, http.StatusMovedPermanently)
+		return nil, errors.New("redirected to current username")
+	}
+
+	return user, nil
+}
+
+func profilePath(user *models.User) string {
+	return "/u/" + url.PathEscape(user.Username)
+}
+
+// avatarURL changes whenever the avatar does, so browsers never show a
+// stale one.
+func avatarURL(r *http.Request, user *models.User) string {
+	if user.Avatar == "" || user.Username == "" {
+		return ""
+	}
+
+	return urls.Path(r, profilePath(user)+"/avatar?v="+url.QueryEscape(strings.TrimSuffix(user.Avatar, ".jpg")))
+}
@@ -0,0 +1,218 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/IrakliGiorgadze/go-web-app/context"
+	"github.com/IrakliGiorgadze/go-web-app/errors"
+	"github.com/IrakliGiorgadze/go-web-app/models"
+	"github.com/IrakliGiorgadze/go-web-app/urls"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// The resumable upload endpoints implement the core, creation, expiration
+// and termination parts of the tus 1.0.0 protocol (https://tus.io).
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,expiration,termination"
+)
+
+func (g Galleries) UploadOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(g.UploadLimits.fileSize(), 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (g Galleries) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	if !g.checkTusVersion(w, r) {
+		return
+	}
+
+	gallery, err := g.galleryByID(w, r, g.userMustHaveRole(models.RoleEditor))
+	if err != nil {
+		return
+	}
+
+	size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || size <= 0 {
+		g.Errors.Message(w, r, http.StatusBadRequest, "Invalid Upload-Length")
+		return
+	}
+	if size > g.UploadLimits.fileSize() {
+		msg := fmt.Sprintf("The file is larger than the %s limit.", formatBytes(g.UploadLimits.fileSize()))
+		g.Errors.Message(w, r, http.StatusRequestEntityTooLarge, msg)
+		return
+	}
+
+	filename := uploadMetadata(r.Header.Get("Upload-Metadata"))["filename"]
+	if filename == "" {
+		g.Errors.Message(w, r, http.StatusBadRequest, "Missing filename in Upload-Metadata")
+		return
+	}
+
+	user := context.User(r.Context())
+	upload, err := g.UploadService.Create(gallery.ID, user.ID, filename, size)
+	if err != nil {
+		var fileErr models.FileError
+		if errors.As(err, &fileErr) {
+			msg := fmt.Sprintf("%v has an invalid extension. Only png, gif, jpeg and jpg files can be uploaded.", filename)
+			g.Errors.Message(w, r, http.StatusBadRequest, msg)
+			return
+		}
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	location := urls.Path(r, fmt.Sprintf("/galleries/%d/uploads/%s", gallery.ID, upload.Token))
+	w.Header().Set("Location", location)
+	setUploadHeaders(w, upload)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (g Galleries) UploadStatus(w http.ResponseWriter, r *http.Request) {
+	upload, err := g.uploadByToken(w, r)
+	if err != nil {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Size, 10))
+	setUploadHeaders(w, upload)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (g Galleries) AppendUpload(w http.ResponseWriter, r *http.Request) {
+	upload, err := g.uploadByToken(w, r)
+	if err != nil {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		g.Errors.Message(w, r, http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream")
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		g.Errors.Message(w, r, http.StatusBadRequest, "Invalid Upload-Offset")
+		return
+	}
+
+	err = g.UploadService.Append(upload, offset, r.Body)
+	if err != nil {
+		if errors.Is(err, models.ErrUploadConflict) {
+			g.Errors.Message(w, r, http.StatusConflict, "Upload-Offset does not match the current offset")
+			return
+		}
+
+		result := UploadResult{
+			Filename: upload.Filename,
+			Error:    "Something went wrong while saving the file.",
+		}
+		status := http.StatusInternalServerError
+		var fileErr models.FileError
+		if errors.As(err, &fileErr) {
+			result.Error = "Invalid content type or extension. Only png, gif, jpeg and jpg files can be uploaded."
+			status = http.StatusUnprocessableEntity
+		} else {
+			logf(r, "appending to upload %d: %v", upload.ID, err)
+		}
+		setUploadHeaders(w, upload)
+		writeJSON(w, status, result)
+		return
+	}
+
+	setUploadHeaders(w, upload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (g Galleries) DeleteUpload(w http.ResponseWriter, r *http.Request) {
+	upload, err := g.uploadByToken(w, r)
+	if err != nil {
+		return
+	}
+
+	err = g.UploadService.Delete(upload)
+	if err != nil {
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (g Galleries) uploadByToken(w http.ResponseWriter, r *http.Request) (*models.Upload, error) {
+	if !g.checkTusVersion(w, r) {
+		return nil, fmt.Errorf("unsupported tus version")
+	}
+
+	gallery, err := g.galleryByID(w, r, g.userMustHaveRole(models.RoleEditor))
+	if err != nil {
+		return nil, err
+	}
+
+	upload, err := g.UploadService.ByToken(gallery.ID, chi.URLParam(r, "token"))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNotFound):
+			g.Errors.Message(w, r, http.StatusNotFound, "Upload not found")
+		case errors.Is(err, models.ErrUploadExpired):
+			g.Errors.Message(w, r, http.StatusGone, "Upload has expired")
+		default:
+			g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		}
+		return nil, err
+	}
+
+	user := context.User(r.Context())
+	if upload.UserID != user.ID {
+		g.Errors.Message(w, r, http.StatusNotFound, "Upload not found")
+		return nil, fmt.Errorf("user does not own this upload")
+	}
+
+	return upload, nil
+}
+
+func (g Galleries) checkTusVersion(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		g.Errors.Message(w, r, http.StatusPreconditionFailed, "Unsupported Tus-Resumable version")
+		return false
+	}
+
+	return true
+}
+
+func setUploadHeaders(w http.ResponseWriter, upload *models.Upload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Received, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+}
+
+// uploadMetadata decodes an Upload-Metadata header of comma separated
+// "key base64(value)" pairs.
+func uploadMetadata(header string) map[string]string {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			continue
+		}
+		metadata[key] = string(decoded)
+	}
+
+	return metadata
+}
@@ -0,0 +1,322 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IrakliGiorgadze/go-web-app/context"
+	"github.com/IrakliGiorgadze/go-web-app/errors"
+	"github.com/IrakliGiorgadze/go-web-app/flash"
+	"github.com/IrakliGiorgadze/go-web-app/models"
+	"github.com/IrakliGiorgadze/go-web-app/urls"
+	"github.com/IrakliGiorgadze/go-web-app/validate"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	MaxSelectionNameLength    = 100
+	MaxSelectionCommentLength = 2000
+	MaxSelectionNoteLength    = 500
+)
+
+// ToggleFavorite adds an image to the visitor's selection, or takes it out
+// again. Visitors don't need an account; their selection is kept in a
+// cookie until they submit it.
+func (g Galleries) ToggleFavorite(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+
+	_, err = g.visitorAccess(w, r, gallery)
+	if err != nil {
+		return
+	}
+
+	image, err := g.GalleryService.ImageByFilename(gallery.ID, g.filename(w, r))
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			g.Errors.Message(w, r, http.StatusNotFound, "Image not found")
+			return
+		}
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	selection, err := g.draftSelection(w, r, gallery, true)
+	if err != nil {
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	_, err = g.SelectionService.Toggle(selection.ID, image.ID)
+	if err != nil {
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	returnTo := galleryReturnPath(r, gallery)
+	if returnTo == fmt.Sprintf("/galleries/%d", gallery.ID) {
+		returnTo += "#image-" + strconv.Itoa(image.ID)
+	}
+	urls.Redirect(w, r, returnTo, http.StatusFound)
+}
+
+// Selection shows the visitor's favourites so that they can add notes and
+// send them to the owner.
+func (g Galleries) Selection(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+
+	_, err = g.visitorAccess(w, r, gallery)
+	if err != nil {
+		return
+	}
+
+	selection, err := g.draftSelection(w, r, gallery, false)
+	if err != nil {
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if selection == nil {
+		selection = &models.Selection{}
+	}
+
+	if user := context.User(r.Context()); user != nil && selection.Name == "" {
+		selection.Name = user.Name()
+	}
+
+	g.renderSelection(w, r, gallery, selection)
+}
+
+func (g Galleries) renderSelection(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, selection *models.Selection, errs ...error) {
+	type Image struct {
+		GalleryID       int
+		FilenameEscaped string
+		Name            string
+		Note            string
+		NoteField       string
+	}
+
+	var data struct {
+		ID      int
+		Title   string
+		Name    string
+		Comment string
+		Images  []Image
+	}
+	data.ID = gallery.ID
+	data.Title = gallery.Title
+	data.Name = selection.Name
+	data.Comment = selection.Comment
+	for _, image := range selection.Images {
+		data.Images = append(data.Images, Image{
+			GalleryID:       gallery.ID,
+			FilenameEscaped: url.PathEscape(image.Filename),
+			Name:            image.Name,
+			Note:            image.Note,
+			NoteField:       noteField(image.ImageID),
+		})
+	}
+
+	g.Templates.Selection.Execute(w, r, data, errs...)
+}
+
+// SubmitSelection sends the visitor's favourites to the owner, who is told
+// about them by email.
+func (g Galleries) SubmitSelection(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+
+	_, err = g.visitorAccess(w, r, gallery)
+	if err != nil {
+		return
+	}
+
+	selection, err := g.draftSelection(w, r, gallery, false)
+	if err != nil {
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if selection == nil || len(selection.Images) == 0 {
+		g.Errors.Message(w, r, http.StatusBadRequest, "You haven't picked any favorites yet.")
+		return
+	}
+
+	selection.Name = strings.TrimSpace(r.FormValue("name"))
+	selection.Comment = strings.TrimSpace(r.FormValue("comment"))
+
+	var v validate.Validator
+	v.Field("name", selection.Name, validate.Required(), validate.MaxLength(MaxSelectionNameLength))
+	v.Field("comment", selection.Comment, validate.MaxLength(MaxSelectionCommentLength))
+	for i, image := range selection.Images {
+		field := noteField(image.ImageID)
+		selection.Images[i].Note = strings.TrimSpace(r.FormValue(field))
+		v.Field(field, selection.Images[i].Note, validate.MaxLength(MaxSelectionNoteLength))
+	}
+	if !v.Valid() {
+		g.renderSelection(w, r, gallery, selection, v.Err())
+		return
+	}
+
+	err = g.SelectionService.Submit(selection, clientAddr(r))
+	if err != nil {
+		if errors.Is(err, models.ErrTooManySelections) {
+			err = errors.Public(err, "You've sent several selections already. Try again in an hour.")
+			g.renderSelection(w, r, gallery, selection, err)
+			return
+		}
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	deleteCookie(w, r, gallerySelectionCookie(gallery.ID))
+	g.notifyOwners(r, gallery, selection)
+
+	g.Flash.Add(w, r, flash.Success, localize(r, "Thank you! Your selection has been sent."))
+	urls.Redirect(w, r, fmt.Sprintf("/galleries/%d", gallery.ID), http.StatusFound)
+}
+
+// notifyOwners emails the gallery's owners about a submitted selection. The
+// selection is saved by then and the owners see it on the gallery page
+// anyway, so failures are only logged rather than shown to the visitor.
+func (g Galleries) notifyOwners(r *http.Request, gallery *models.Gallery, selection *models.Selection) {
+	members, err := g.GalleryService.Members(gallery.ID)
+	if err != nil {
+		logf(r, "notifying the owners of gallery %d about selection %d: %v", gallery.ID, selection.ID, err)
+		return
+	}
+	for _, member := range members {
+		if member.Role != models.RoleOwner {
+			continue
+		}
+		err = g.EmailService.SelectionSubmitted(
+			member.Email,
+			member.Locale,
+			gallery.Title,
+			selection,
+			urls.Absolute(r, fmt.Sprintf("/galleries/%d/edit#selections", gallery.ID)),
+		)
+		if err != nil {
+			logf(r, "emailing user %d about selection %d: %v", member.UserID, selection.ID, err)
+		}
+	}
+}
+
+// ExportSelection downloads a submitted selection as a CSV file with the
+// visitor's notes, or with format=txt as a list of the original filenames,
+// which photo editors can search for.
+func (g Galleries) ExportSelection(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userMustHaveRole(models.RoleOwner))
+	if err != nil {
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "selectionID"))
+	if err != nil {
+		g.Errors.Message(w, r, http.StatusNotFound, "Selection not found")
+		return
+	}
+
+	selection, err := g.SelectionService.ByID(gallery.ID, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			g.Errors.Message(w, r, http.StatusNotFound, "Selection not found")
+			return
+		}
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if r.FormValue("format") == "txt" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="selection-%d.txt"`, selection.ID))
+		for _, image := range selection.Images {
+			fmt.Fprintln(w, image.Name)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="selection-%d.csv"`, selection.ID))
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"name", "filename", "note", "selected_by", "submitted_at"})
+	for _, image := range selection.Images {
+		cw.Write([]string{
+			csvCell(image.Name),
+			csvCell(image.Filename),
+			csvCell(image.Note),
+			csvCell(selection.Name),
+			selection.SubmittedAt.Format(time.RFC3339),
+		})
+	}
+	cw.Flush()
+	if cw.Error() != nil {
+		// The response has already started, so all we can do is log.
+		logf(r, "exporting selection %d: %v", selection.ID, cw.Error())
+	}
+}
+
+// csvCell keeps spreadsheets from running a visitor's text as a formula, by
+// prefixing a quote to text that starts like one.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+
+	return s
+}
+
+// draftSelection returns the selection the visitor is making from the
+// gallery. If they haven't started one, a new one is made when create is
+// set, and nil is returned otherwise.
+func (g Galleries) draftSelection(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, create bool) (*models.Selection, error) {
+	name := gallerySelectionCookie(gallery.ID)
+	token, err := readCookie(r, name)
+	if err == nil {
+		selection, err := g.SelectionService.Draft(gallery.ID, token)
+		if err == nil {
+			return selection, nil
+		}
+		if !errors.Is(err, models.ErrNotFound) {
+			return nil, err
+		}
+	}
+
+	if !create {
+		return nil, nil
+	}
+
+	var userID int
+	if user := context.User(r.Context()); user != nil {
+		userID = user.ID
+	}
+
+	selection, err := g.SelectionService.Create(gallery.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	cookie := newCookie(r, name, selection.Token)
+	cookie.Expires = time.Now().Add(GalleryAccessDuration)
+	http.SetCookie(w, cookie)
+
+	return selection, nil
+}
+
+func gallerySelectionCookie(galleryID int) string {
+	return CookieGallerySelection + strconv.Itoa(galleryID)
+}
+
+func noteField(imageID int) string {
+	return "note-" + strconv.Itoa(imageID)
+}
@@ -0,0 +1,254 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IrakliGiorgadze/go-web-app/context"
+	"github.com/IrakliGiorgadze/go-web-app/errors"
+	"github.com/IrakliGiorgadze/go-web-app/flash"
+	"github.com/IrakliGiorgadze/go-web-app/models"
+	"github.com/IrakliGiorgadze/go-web-app/urls"
+	"github.com/IrakliGiorgadze/go-web-app/validate"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const MaxShareLinkLabelLength = 100
+
+// ShareLinks lists the links the owner has made for sharing the gallery.
+func (g Galleries) ShareLinks(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userMustHaveRole(models.RoleOwner))
+	if err != nil {
+		return
+	}
+
+	g.renderShareLinks(w, r, gallery, "", nil)
+}
+
+// renderShareLinks shows the share links page. The URL of a link is only
+// known when it is created, so newURL is shown once and never again.
+func (g Galleries) renderShareLinks(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, newURL string, form url.Values, errs ...error) {
+	type ShareLink struct {
+		ID             int
+		Label          string
+		CreatedAt      time.Time
+		ExpiresAt      time.Time
+		AllowDownloads bool
+		Views          int
+		Downloads      int
+		Status         string
+		Active         bool
+	}
+
+	var data struct {
+		ID          int
+		Title       string
+		HasPassword bool
+		NewURL      string
+		Links       []ShareLink
+		Label       string
+		Expires     string
+		Downloads   bool
+	}
+	data.ID = gallery.ID
+	data.Title = gallery.Title
+	data.HasPassword = gallery.PasswordHash != ""
+	data.NewURL = newURL
+	data.Label = form.Get("label")
+	data.Expires = form.Get("expires")
+	data.Downloads = form.Get("allow_downloads") == "true"
+
+	links, err := g.ShareLinkService.ByGallery(gallery.ID)
+	if err != nil {
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	for _, link := range links {
+		status := localize(r, "Active")
+		switch {
+		case !link.RevokedAt.IsZero():
+			status = localize(r, "Revoked")
+		case link.Expired():
+			status = localize(r, "Expired")
+		}
+		data.Links = append(data.Links, ShareLink{
+			ID:             link.ID,
+			Label:          link.Label,
+			CreatedAt:      link.CreatedAt,
+			ExpiresAt:      link.ExpiresAt,
+			AllowDownloads: link.AllowDownloads,
+			Views:          link.Views,
+			Downloads:      link.Downloads,
+			Status:         status,
+			Active:         link.Active(),
+		})
+	}
+
+	g.Templates.ShareLinks.Execute(w, r, data, errs...)
+}
+
+func (g Galleries) CreateShareLink(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userMustHaveRole(models.RoleOwner))
+	if err != nil {
+		return
+	}
+
+	link := models.ShareLink{
+		GalleryID:      gallery.ID,
+		Label:          strings.TrimSpace(r.FormValue("label")),
+		AllowDownloads: r.FormValue("allow_downloads") == "true",
+		CreatedBy:      context.User(r.Context()).ID,
+	}
+
+	var v validate.Validator
+	v.Field("label", link.Label, validate.MaxLength(MaxShareLinkLabelLength))
+	// Without a password the gallery itself serves the originals to
+	// anyone, so a link that withholds them would promise too much.
+	if !link.AllowDownloads && gallery.PasswordHash == "" {
+		v.Add("allow_downloads", "Set a password on the gallery before making links without downloads.")
+	}
+	if expires := r.FormValue("expires"); expires != "" {
+		// Links work until the end of the day they expire on.
+		day, err := time.ParseInLocation("2006-01-02", expires, time.Local)
+		switch {
+		case err != nil:
+			v.Add("expires", "Enter a date like 2024-12-31.")
+		case !day.AddDate(0, 0, 1).After(time.Now()):
+			v.Add("expires", "Choose a date that hasn't passed.")
+		default:
+			link.ExpiresAt = day.AddDate(0, 0, 1)
+		}
+	}
+	if !v.Valid() {
+		g.renderShareLinks(w, r, gallery, "", r.Form, v.Err())
+		return
+	}
+
+	err = g.ShareLinkService.Create(&link)
+	if err != nil {
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	g.renderShareLinks(w, r, gallery, urls.Absolute(r, "/share/"+link.Token), nil)
+}
+
+func (g Galleries) RevokeShareLink(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userMustHaveRole(models.RoleOwner))
+	if err != nil {
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "linkID"))
+	if err != nil {
+		g.Errors.Message(w, r, http.StatusNotFound, "Share link not found")
+		return
+	}
+
+	err = g.ShareLinkService.Revoke(gallery.ID, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			g.Errors.Message(w, r, http.StatusNotFound, "Share link not found")
+			return
+		}
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	g.Flash.Add(w, r, flash.Success, localize(r, "Share link revoked. It no longer works."))
+	urls.Redirect(w, r, fmt.Sprintf("/galleries/%d/links", gallery.ID), http.StatusFound)
+}
+
+// FollowShareLink remembers the link in a cookie, so that the images on the
+// gallery page can be seen through it too, and shows the gallery.
+func (g Galleries) FollowShareLink(w http.ResponseWriter, r *http.Request) {
+	link, err := g.ShareLinkService.ByToken(chi.URLParam(r, "token"))
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) || errors.Is(err, models.ErrTokenExpired) {
+			g.Errors.Message(w, r, http.StatusNotFound, "That share link is invalid or has expired. Ask for a new one.")
+			return
+		}
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	cookie := newCookie(r, galleryShareCookie(link.GalleryID), link.Token)
+	cookie.Expires = link.ExpiresAt
+	if cookie.Expires.IsZero() {
+		cookie.Expires = time.Now().Add(GalleryAccessDuration)
+	}
+	http.SetCookie(w, cookie)
+
+	urls.Redirect(w, r, fmt.Sprintf("/galleries/%d", link.GalleryID), http.StatusFound)
+}
+
+// visitorAccess lets the visitor see the gallery if they are a member, came
+// through a share link, or know its password. The share link is returned so
+// that the handler can count the visit and apply its download setting;
+// members see the gallery in full whatever link they have.
+func (g Galleries) visitorAccess(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) (*models.ShareLink, error) {
+	role, err := g.memberRole(r, gallery)
+	if err != nil {
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return nil, err
+	}
+	if role.Can(models.RoleViewer) {
+		return nil, nil
+	}
+
+	link, err := g.shareLink(w, r, gallery)
+	if err != nil {
+		g.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return nil, err
+	}
+	if link != nil {
+		return link, nil
+	}
+
+	return nil, g.visitorMustKnowPassword(w, r, gallery)
+}
+
+// memberRole returns the signed in user's role in the gallery, which is
+// empty for visitors who aren't members.
+func (g Galleries) memberRole(r *http.Request, gallery *models.Gallery) (models.Role, error) {
+	user := context.User(r.Context())
+	if user == nil {
+		return "", nil
+	}
+
+	return g.GalleryService.Role(gallery.ID, user.ID)
+}
+
+// shareLink returns the link the visitor followed to the gallery, or nil if
+// they didn't follow one or it has stopped working.
+func (g Galleries) shareLink(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) (*models.ShareLink, error) {
+	name := galleryShareCookie(gallery.ID)
+	token, err := readCookie(r, name)
+	if err != nil {
+		return nil, nil
+	}
+
+	link, err := g.ShareLinkService.ByToken(token)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) || errors.Is(err, models.ErrTokenExpired) {
+			deleteCookie(w, r, name)
+			return nil, nil
+		}
+		return nil, err
+	}
+	if link.GalleryID != gallery.ID {
+		deleteCookie(w, r, name)
+		return nil, nil
+	}
+
+	return link, nil
+}
+
+func galleryShareCookie(galleryID int) string {
+	return CookieGalleryShare + strconv.Itoa(galleryID)
+}
@@ -1,7 +1,32 @@
 package controllers
 
-import "net/http"
+import (
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
 
 type Template interface {
 	Execute(w http.ResponseWriter, r *http.Request, data any, errs ...error)
 }
+
+// executeStatus renders tpl with a status other than 200 OK. The page is
+// buffered like the error pages are, so that if the template fails its own
+// error goes out rather than a broken page under status.
+func executeStatus(w http.ResponseWriter, r *http.Request, tpl Template, status int, data any, errs ...error) {
+	var page pageBuffer
+	tpl.Execute(&page, r, data, errs...)
+	if page.status != 0 {
+		status = page.status
+	}
+
+	for key, values := range page.header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(status)
+	_, err := page.body.WriteTo(w)
+	if err != nil {
+		log.Printf("[%s] writing the %d page: %v", middleware.GetReqID(r.Context()), status, err)
+	}
+}
@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/IrakliGiorgadze/go-web-app/models"
+)
+
+const (
+	DefaultMaxFileSize    = 64 << 20  // 64mb
+	DefaultMaxRequestSize = 256 << 20 // 256mb
+	DefaultMaxArchiveSize = 2 << 30   // 2gb
+)
+
+type UploadLimits struct {
+	MaxFileSize    int64
+	MaxRequestSize int64
+	MaxArchiveSize int64
+	Zip            models.ZipLimits
+}
+
+func (l UploadLimits) fileSize() int64 {
+	if l.MaxFileSize <= 0 {
+		return DefaultMaxFileSize
+	}
+
+	return l.MaxFileSize
+}
+
+func (l UploadLimits) requestSize() int64 {
+	if l.MaxRequestSize <= 0 {
+		return DefaultMaxRequestSize
+	}
+
+	return l.MaxRequestSize
+}
+
+func (l UploadLimits) archiveSize() int64 {
+	if l.MaxArchiveSize <= 0 {
+		return DefaultMaxArchiveSize
+	}
+
+	return l.MaxArchiveSize
+}
+
+var (
+	uploadPathRe = regexp.MustCompile(`^/galleries/[^/]+/images/?$`)
+	importPathRe = regexp.MustCompile(`^/galleries/[^/]+/import/?$`)
+)
+
+// LimitUploads caps the body of upload and import requests. It has to run
+// before the CSRF middleware, which parses the multipart form of requests
+// that send the token as a form field and would otherwise buffer the whole
+// body before eachUpload applies its own limit.
+func (g Galleries) LimitUploads(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var limit int64
+		if r.Method == http.MethodPost {
+			switch {
+			case uploadPathRe.MatchString(r.URL.Path):
+				limit = g.UploadLimits.requestSize()
+			case importPathRe.MatchString(r.URL.Path):
+				limit = g.UploadLimits.archiveSize()
+			}
+		}
+		if limit > 0 {
+			if r.ContentLength > limit {
+				g.Errors.Message(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("The upload exceeded the %s limit per request.", formatBytes(limit)))
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type UploadResult struct {
+	Filename string `json:"filename"`
+	Error    string `json:"error,omitempty"`
+}
+
+func (ur UploadResult) OK() bool {
+	return ur.Error == ""
+}
+
+// eachUpload calls fn for every file sent in the given form field. Requests
+// with the CSRF token in a header are streamed part by part; when the CSRF
+// middleware has already parsed the multipart form we fall back to it.
+func eachUpload(w http.ResponseWriter, r *http.Request, field string, maxSize int64, fn func(filename string, contents io.Reader) error) error {
+	if r.MultipartForm != nil {
+		var total int64
+		for _, fileHeader := range r.MultipartForm.File[field] {
+			total += fileHeader.Size
+			if total > maxSize {
+				return &http.MaxBytesError{Limit: maxSize}
+			}
+
+			file, err := fileHeader.Open()
+			if err != nil {
+				return fmt.Errorf("open uploaded file: %w", err)
+			}
+			err = fn(fileHeader.Filename, file)
+			file.Close()
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return fmt.Errorf("multipart reader: %w", err)
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("next part: %w", err)
+		}
+
+		if part.FormName() != field || part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		err = fn(part.FileName(), part)
+		part.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// storeUpload spools contents to a temporary file so that the gallery
+// service can inspect and seek it without holding the file in memory. A
+// non-nil error means the request itself failed and no further files
+// should be read.
+func (g Galleries) storeUpload(galleryID, userID int, filename string, contents io.Reader) (UploadResult, error) {
+	result := UploadResult{
+		Filename: filename,
+	}
+
+	tmp, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return result, fmt.Errorf("store upload: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	maxSize := g.UploadLimits.fileSize()
+	n, err := io.Copy(tmp, io.LimitReader(contents, maxSize+1))
+	if err != nil {
+		return result, fmt.Errorf("store upload: %w", err)
+	}
+
+	switch {
+	case n == 0:
+		result.Error = "The file is empty."
+		return result, nil
+	case n > maxSize:
+		result.Error = fmt.Sprintf("The file is larger than the %s limit.", formatBytes(maxSize))
+		return result, nil
+	}
+
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return result, fmt.Errorf("store upload: %w", err)
+	}
+
+	_, err = g.GalleryService.CreateImage(galleryID, userID, filename, tmp)
+	if err != nil {
+		var fileErr models.FileError
+		if errors.As(err, &fileErr) {
+			result.Error = "Invalid content type or extension. Only png, gif, jpeg and jpg files can be uploaded."
+			return result, nil
+		}
+		log.Printf("saving %s to gallery %d: %v", filename, galleryID, err)
+		result.Error = "Something went wrong while saving the file."
+	}
+
+	return result, nil
+}
+
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("writing a JSON response: %v", err)
+	}
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%dGB", n>>30)
+	case n >= 1<<20:
+		return fmt.Sprintf("%dMB", n>>20)
+	case n >= 1<<10:
+		return fmt.Sprintf("%dKB", n>>10)
+	default:
+		return fmt.Sprintf("%dB", n)
+	}
+}
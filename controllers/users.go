@@ -1,13 +1,28 @@
 package controllers
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"net/http"
-	"net/url"
 
 	"github.com/IrakliGiorgadze/go-web-app/context"
 	"github.com/IrakliGiorgadze/go-web-app/errors"
+	"github.com/IrakliGiorgadze/go-web-app/flash"
 	"github.com/IrakliGiorgadze/go-web-app/models"
+	"github.com/IrakliGiorgadze/go-web-app/urls"
+	"github.com/IrakliGiorgadze/go-web-app/validate"
+)
+
+const (
+	MaxEmailLength    = 254
+	MinPasswordLength = 8
+	// bcrypt only uses the first 72 bytes of a password.
+	MaxPasswordBytes = 72
+
+	MaxDisplayNameLength = 50
+	MaxBioLength         = 500
+	MaxAvatarSize        = 5 << 20
 )
 
 type Users struct {
@@ -17,11 +32,14 @@ type Users struct {
 		ForgotPassword Template
 		CheckYourEmail Template
 		ResetPassword  Template
+		Profile        Template
 	}
 	UserService          *models.UserService
 	SessionService       *models.SessionService
 	PasswordResetService *models.PasswordResetService
 	EmailService         *models.EmailService
+	Errors               ErrorPages
+	Flash                Flasher
 }
 
 func (u Users) New(w http.ResponseWriter, r *http.Request) {
@@ -33,34 +51,49 @@ func (u Users) New(w http.ResponseWriter, r *http.Request) {
 	u.Templates.New.Execute(w, r, data)
 }
 
+// Create responds the same way whether or not the email address is taken,
+// so that sign up can't be used to find out who has an account. Either way
+// the address is emailed, and the user signs in from there.
 func (u Users) Create(w http.ResponseWriter, r *http.Request) {
 	var data struct {
 		Email    string
 		Password string
+		Signup   bool
 	}
 
 	data.Email = r.FormValue("email")
 	data.Password = r.FormValue("password")
+	data.Signup = true
+
+	var v validate.Validator
+	v.Field("email", data.Email, validate.Required(), validate.Email(), validate.MaxLength(MaxEmailLength))
+	validatePassword(&v, data.Password, r.FormValue("password_confirm"))
+	if !v.Valid() {
+		data.Password = ""
+		u.Templates.New.Execute(w, r, data, v.Err())
+		return
+	}
 
-	user, err := u.UserService.Create(data.Email, data.Password)
-	if err != nil {
-		if errors.Is(err, models.ErrEmailTaken) {
-			err = errors.Public(err, "That email address is already associated with an account.")
-		}
+	_, err := u.UserService.Create(data.Email, data.Password)
+	signInURL := urls.Absolute(r, "/signin")
+	resetURL := urls.Absolute(r, "/forgot-pw")
+	switch {
+	case errors.Is(err, models.ErrEmailTaken):
+		err = u.EmailService.AccountExists(data.Email, locale(r), signInURL, resetURL)
+	case err != nil:
+		data.Password = ""
 		u.Templates.New.Execute(w, r, data, err)
 		return
+	default:
+		err = u.EmailService.Welcome(data.Email, locale(r), signInURL, resetURL)
 	}
-
-	session, err := u.SessionService.Create(user.ID)
 	if err != nil {
-		fmt.Println(err)
-		http.Redirect(w, r, "/signin", http.StatusFound)
+		u.Errors.Render(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
-	setCookie(w, CookieSession, session.Token)
-
-	http.Redirect(w, r, "/galleries", http.StatusFound)
+	data.Password = ""
+	u.Templates.CheckYourEmail.Execute(w, r, data)
 }
 
 func (u Users) SignIn(w http.ResponseWriter, r *http.Request) {
@@ -80,47 +113,178 @@ func (u Users) ProcessSignIn(w http.ResponseWriter, r *http.Request) {
 	data.Email = r.FormValue("email")
 	data.Password = r.FormValue("password")
 
+	var v validate.Validator
+	v.Field("email", data.Email, validate.Required())
+	v.Field("password", data.Password, validate.Required())
+	if !v.Valid() {
+		u.Templates.SignIn.Execute(w, r, data, v.Err())
+		return
+	}
+
 	user, err := u.UserService.Authenticate(data.Email, data.Password)
 	if err != nil {
-		fmt.Println(err)
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		if !errors.Is(err, models.ErrInvalidCredentials) {
+			u.Errors.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		err = errors.Public(err, "The email address or password is incorrect.")
+		u.Templates.SignIn.Execute(w, r, data, err)
 		return
 	}
 
 	session, err := u.SessionService.Create(user.ID)
 	if err != nil {
-		fmt.Println(err)
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		u.Errors.Render(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
-	setCookie(w, CookieSession, session.Token)
+	setCookie(w, r, CookieSession, session.Token)
 
-	http.Redirect(w, r, "/galleries", http.StatusFound)
+	urls.Redirect(w, r, "/galleries", http.StatusFound)
 }
 
+// CurrentUser shows the settings for the signed in user's profile.
 func (u Users) CurrentUser(w http.ResponseWriter, r *http.Request) {
 	user := context.User(r.Context())
-	_, _ = fmt.Fprintf(w, "Current user: %s\n", user.Email)
+	u.renderProfile(w, r, user)
+}
+
+func (u Users) renderProfile(w http.ResponseWriter, r *http.Request, user *models.User, errs ...error) {
+	var data struct {
+		Email         string
+		Username      string
+		DisplayName   string
+		Bio           string
+		AvatarURL     string
+		ProfileURL    string
+		MaxAvatarSize string
+		// MaxRedirects is how many old usernames keep linking to the
+		// profile.
+		MaxRedirects int
+	}
+	data.Email = user.Email
+	data.Username = user.Username
+	data.DisplayName = user.DisplayName
+	data.Bio = user.Bio
+	data.AvatarURL = avatarURL(r, user)
+	if user.Username != "" {
+		data.ProfileURL = urls.Path(r, profilePath(user))
+	}
+	data.MaxAvatarSize = formatBytes(MaxAvatarSize)
+	data.MaxRedirects = models.MaxUsernameRedirects
+
+	u.Templates.Profile.Execute(w, r, data, errs...)
+}
+
+func (u Users) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	user := *context.User(r.Context())
+	user.Username = r.FormValue("username")
+	user.DisplayName = r.FormValue("display_name")
+	user.Bio = r.FormValue("bio")
+
+	var v validate.Validator
+	v.Field("username", user.Username, validate.Required())
+	v.Field("display_name", user.DisplayName, validate.MaxLength(MaxDisplayNameLength))
+	v.Field("bio", user.Bio, validate.MaxLength(MaxBioLength))
+	if !v.Valid() {
+		u.renderProfile(w, r, &user, v.Err())
+		return
+	}
+
+	err := u.UserService.UpdateProfile(&user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidUsername):
+			v.Add("username", "Must be %d to %d lowercase letters, numbers or underscores.", models.MinUsernameLength, models.MaxUsernameLength)
+		case errors.Is(err, models.ErrUsernameReserved):
+			v.Add("username", "That username is reserved.")
+		case errors.Is(err, models.ErrUsernameTaken):
+			v.Add("username", "That username is taken.")
+		default:
+			u.Errors.Render(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		u.renderProfile(w, r, &user, v.Err())
+		return
+	}
+
+	u.Flash.Add(w, r, flash.Success, localize(r, "Profile saved."))
+	urls.Redirect(w, r, "/users/me", http.StatusFound)
+}
+
+func (u Users) UpdateAvatar(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+
+	var received bool
+	err := eachUpload(w, r, "avatar", MaxAvatarSize, func(filename string, contents io.Reader) error {
+		if received {
+			return nil
+		}
+		received = true
+
+		b, err := io.ReadAll(contents)
+		if err != nil {
+			return err
+		}
+		_, err = u.UserService.SetAvatar(user.ID, bytes.NewReader(b))
+		return err
+	})
+	if err == nil && !received {
+		err = errors.Public(fmt.Errorf("update avatar: no file"), "Please choose an image.")
+	}
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		var fileErr models.FileError
+		switch {
+		case errors.As(err, &maxBytesErr):
+			err = errors.Public(err, "The image is too large.")
+		case errors.As(err, &fileErr):
+			err = errors.Public(err, "Invalid content type or extension. Only png, gif, jpeg and jpg files can be uploaded.")
+		default:
+			var pubErr interface{ Public() string }
+			if !errors.As(err, &pubErr) {
+				u.Errors.Render(w, r, http.StatusInternalServerError, err)
+				return
+			}
+		}
+		u.renderProfile(w, r, user, err)
+		return
+	}
+
+	u.Flash.Add(w, r, flash.Success, localize(r, "Avatar updated."))
+	urls.Redirect(w, r, "/users/me", http.StatusFound)
+}
+
+func (u Users) DeleteAvatar(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+
+	err := u.UserService.RemoveAvatar(user.ID)
+	if err != nil {
+		u.Errors.Render(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	u.Flash.Add(w, r, flash.Success, localize(r, "Avatar removed."))
+	urls.Redirect(w, r, "/users/me", http.StatusFound)
 }
 
 func (u Users) ProcessSignOut(w http.ResponseWriter, r *http.Request) {
 	token, err := readCookie(r, CookieSession)
 	if err != nil {
-		http.Redirect(w, r, "/signin", http.StatusFound)
+		urls.Redirect(w, r, "/signin", http.StatusFound)
 		return
 	}
 
 	err = u.SessionService.Delete(token)
 	if err != nil {
-		fmt.Println(err)
-		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		u.Errors.Render(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
-	deleteCookie(w, CookieSession)
+	deleteCookie(w, r, CookieSession)
+	u.Flash.Add(w, r, flash.Info, localize(r, "You've been signed out."))
 
-	http.Redirect(w, r, "/signin", http.StatusFound)
+	urls.Redirect(w, r, "/signin", http.StatusFound)
 }
 
 func (u Users) ForgotPassword(w http.ResponseWriter, r *http.Request) {
@@ -132,29 +296,29 @@ func (u Users) ForgotPassword(w http.ResponseWriter, r *http.Request) {
 	u.Templates.ForgotPassword.Execute(w, r, data)
 }
 
+// ProcessForgotPassword looks the same whether or not the address has an
+// account. The lookup and the email happen in the background.
 func (u Users) ProcessForgotPassword(w http.ResponseWriter, r *http.Request) {
 	var data struct {
-		Email string
+		Email  string
+		Signup bool
 	}
 	data.Email = r.FormValue("email")
 
-	pwReset, err := u.PasswordResetService.Create(data.Email)
-	if err != nil {
-		fmt.Println(err)
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+	var v validate.Validator
+	v.Field("email", data.Email, validate.Required(), validate.Email(), validate.MaxLength(MaxEmailLength))
+	if !v.Valid() {
+		u.Templates.ForgotPassword.Execute(w, r, data, v.Err())
 		return
 	}
 
-	vals := url.Values{
-		"token": {pwReset.Token},
-	}
-
-	resetURL := "https://www.pb.com/reset-pw?" + vals.Encode()
-
-	err = u.EmailService.ForgotPassword(data.Email, resetURL)
+	err := u.PasswordResetService.Request(models.PasswordResetRequest{
+		Email:    data.Email,
+		Locale:   locale(r),
+		ResetURL: urls.Absolute(r, "/reset-pw"),
+	})
 	if err != nil {
-		fmt.Println(err)
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		u.Errors.Render(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -178,29 +342,51 @@ func (u Users) ProcessResetPassword(w http.ResponseWriter, r *http.Request) {
 	data.Token = r.FormValue("token")
 	data.Password = r.FormValue("password")
 
+	var v validate.Validator
+	v.Field("token", data.Token, validate.Required())
+	validatePassword(&v, data.Password, r.FormValue("password_confirm"))
+	if !v.Valid() {
+		u.Templates.ResetPassword.Execute(w, r, data, v.Err())
+		return
+	}
+
 	user, err := u.PasswordResetService.Consume(data.Token)
 	if err != nil {
-		fmt.Println(err)
-		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		if errors.Is(err, models.ErrNotFound) || errors.Is(err, models.ErrTokenExpired) {
+			v.Add("token", "That reset link is invalid or has expired. Ask for a new one.")
+			u.Templates.ResetPassword.Execute(w, r, data, v.Err())
+			return
+		}
+		u.Errors.Render(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
 	err = u.UserService.UpdatePassword(user.ID, data.Password)
 	if err != nil {
-		fmt.Println(err)
-		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		u.Errors.Render(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
 	session, err := u.SessionService.Create(user.ID)
 	if err != nil {
-		fmt.Println(err)
-		http.Redirect(w, r, "/signin", http.StatusFound)
+		// The password was changed, so the user can still sign in with it.
+		logf(r, "signing in user %d after a password reset: %v", user.ID, err)
+		urls.Redirect(w, r, "/signin", http.StatusFound)
 		return
 	}
 
-	setCookie(w, CookieSession, session.Token)
-	http.Redirect(w, r, "/users/me", http.StatusFound)
+	setCookie(w, r, CookieSession, session.Token)
+	u.Flash.Add(w, r, flash.Success, localize(r, "Your password has been changed."))
+	urls.Redirect(w, r, "/users/me", http.StatusFound)
+}
+
+func validatePassword(v *validate.Validator, password, confirm string) {
+	v.Field("password", password,
+		validate.Required(),
+		validate.MinLength(MinPasswordLength),
+		validate.MaxBytes(MaxPasswordBytes),
+	)
+	v.Field("password_confirm", confirm, validate.Equals(password, "The passwords don't match."))
 }
 
 type UserMiddleware struct {
@@ -232,7 +418,7 @@ func (umw UserMiddleware) RequireUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := context.User(r.Context())
 		if user == nil {
-			http.Redirect(w, r, "/signin", http.StatusFound)
+			urls.Redirect(w, r, "/signin", http.StatusFound)
 			return
 		}
 
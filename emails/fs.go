@@ -0,0 +1,10 @@
+package emails
+
+import "embed"
+
+// FS holds the email templates. layout.gohtml wraps the HTML body of every
+// email; each locale has a directory with a NAME.txt.gohtml defining the
+// "subject" and "text" templates and a NAME.html.gohtml defining "content".
+//
+//go:embed *
+var FS embed.FS
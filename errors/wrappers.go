@@ -3,6 +3,7 @@ package errors
 import "errors"
 
 var (
-	As = errors.As
-	Is = errors.Is
+	As  = errors.As
+	Is  = errors.Is
+	New = errors.New
 )
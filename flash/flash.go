@@ -0,0 +1,69 @@
+// Package flash encodes short messages that are shown once on the next page
+// a user sees, such as "Gallery saved." after a redirect. Messages travel in
+// a cookie signed with HMAC-SHA256, so they can't be forged to show
+// arbitrary text on the site.
+package flash
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type Level string
+
+const (
+	Success Level = "success"
+	Info    Level = "info"
+	Error   Level = "error"
+)
+
+var ErrInvalid = errors.New("flash: invalid or tampered value")
+
+type Message struct {
+	Level Level  `json:"l"`
+	Text  string `json:"t"`
+}
+
+// Encode serializes and signs msgs for storing in a cookie.
+func Encode(key []byte, msgs []Message) (string, error) {
+	b, err := json.Marshal(msgs)
+	if err != nil {
+		return "", fmt.Errorf("flash: %w", err)
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(b)
+
+	return payload + "." + sign(key, payload), nil
+}
+
+// Decode verifies a value made by Encode and returns its messages.
+func Decode(key []byte, value string) ([]Message, error) {
+	payload, sig, ok := strings.Cut(value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(sign(key, payload))) {
+		return nil, ErrInvalid
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	var msgs []Message
+	err = json.Unmarshal(b, &msgs)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	return msgs, nil
+}
+
+func sign(key []byte, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
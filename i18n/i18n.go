@@ -0,0 +1,275 @@
+// Package i18n translates the text shown to users.
+//
+// Messages are looked up by their English text, so a message that hasn't
+// been translated is shown in English. Each locale has a JSON file, such as
+// locales/ka.json:
+//
+//	{
+//	  "messages": {
+//	    "Sign in": "შესვლა",
+//	    "%d images": {"one": "%d სურათი", "other": "%d სურათი"}
+//	  },
+//	  "dates": {
+//	    "layout": "2 January 2006",
+//	    "months": ["იანვარი", ...]
+//	  }
+//	}
+//
+// A message with plural forms is keyed by CLDR plural category and its first
+// argument is the count. English plurals live in locales/en.json.
+package i18n
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/text/feature/plural"
+	"golang.org/x/text/language"
+)
+
+const (
+	// DefaultLocale is the language the messages are written in.
+	DefaultLocale = "en"
+
+	defaultDateLayout = "Jan 2, 2006"
+)
+
+type Catalog struct {
+	locales map[string]*locale
+	tags    []language.Tag
+	matcher language.Matcher
+}
+
+type locale struct {
+	tag      language.Tag
+	Messages map[string]message `json:"messages"`
+	Dates    struct {
+		Layout      string   `json:"layout"`
+		Months      []string `json:"months"`
+		ShortMonths []string `json:"shortMonths"`
+	} `json:"dates"`
+}
+
+// message is either a plain translation or a set of plural forms.
+type message struct {
+	Text   string
+	Plural map[string]string
+}
+
+func (m *message) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		return json.Unmarshal(b, &m.Text)
+	}
+	return json.Unmarshal(b, &m.Plural)
+}
+
+// Load reads every *.json file in fsys. The file name, such as "ka.json",
+// names the locale.
+func Load(fsys fs.FS) (*Catalog, error) {
+	files, err := fs.Glob(fsys, "*.json")
+	if err != nil {
+		return nil, fmt.Errorf("load catalog: %w", err)
+	}
+
+	c := Catalog{
+		locales: make(map[string]*locale),
+	}
+
+	for _, file := range files {
+		b, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, fmt.Errorf("load catalog: %w", err)
+		}
+
+		var l locale
+		err = json.Unmarshal(b, &l)
+		if err != nil {
+			return nil, fmt.Errorf("load catalog: %s: %w", file, err)
+		}
+
+		code := strings.TrimSuffix(path.Base(file), ".json")
+		l.tag, err = language.Parse(code)
+		if err != nil {
+			return nil, fmt.Errorf("load catalog: %s: %w", file, err)
+		}
+
+		c.locales[code] = &l
+	}
+
+	if _, ok := c.locales[DefaultLocale]; !ok {
+		c.locales[DefaultLocale] = &locale{tag: language.Make(DefaultLocale)}
+	}
+
+	// The default locale goes first so that the matcher falls back to it.
+	codes := make([]string, 0, len(c.locales))
+	for code := range c.locales {
+		if code != DefaultLocale {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	for _, code := range append([]string{DefaultLocale}, codes...) {
+		c.tags = append(c.tags, c.locales[code].tag)
+	}
+	c.matcher = language.NewMatcher(c.tags)
+
+	return &c, nil
+}
+
+// Locales returns the codes of the locales in the catalog, default first.
+func (c *Catalog) Locales() []string {
+	codes := make([]string, 0, len(c.tags))
+	for _, tag := range c.tags {
+		codes = append(codes, tag.String())
+	}
+
+	return codes
+}
+
+// Match returns the best supported locale for prefs, in order of
+// preference. Each can be a locale code or an Accept-Language header;
+// empty ones are skipped.
+func (c *Catalog) Match(prefs ...string) string {
+	for _, pref := range prefs {
+		if pref == "" {
+			continue
+		}
+		tags, _, err := language.ParseAcceptLanguage(pref)
+		if err != nil || len(tags) == 0 {
+			continue
+		}
+		_, i, confidence := c.matcher.Match(tags...)
+		if confidence != language.No {
+			return c.tags[i].String()
+		}
+	}
+
+	return DefaultLocale
+}
+
+// Has reports whether key is translated for the locale.
+func (c *Catalog) Has(code, key string) bool {
+	l, ok := c.locales[code]
+	if !ok {
+		return false
+	}
+	msg, ok := l.Messages[key]
+
+	return ok && (msg.Text != "" || len(msg.Plural) > 0)
+}
+
+// Localizer returns a Localizer for the locale, or the default locale if
+// the catalog doesn't have it.
+func (c *Catalog) Localizer(code string) *Localizer {
+	l, ok := c.locales[code]
+	if !ok {
+		code = DefaultLocale
+		l = c.locales[code]
+	}
+
+	return &Localizer{
+		code:    code,
+		locale:  l,
+		english: c.locales[DefaultLocale],
+	}
+}
+
+// Localizer translates into one locale. A nil Localizer leaves messages in
+// English.
+type Localizer struct {
+	code    string
+	locale  *locale
+	english *locale
+}
+
+func (l *Localizer) Locale() string {
+	if l == nil {
+		return DefaultLocale
+	}
+
+	return l.code
+}
+
+// T translates key and formats it with args as fmt.Sprintf does. When key
+// has plural forms, args[0] is the count that picks the form.
+func (l *Localizer) T(key string, args ...any) string {
+	format := key
+	if msg, tag, ok := l.lookup(key); ok {
+		if msg.Plural != nil {
+			format = pluralForm(tag, msg.Plural, args)
+		} else {
+			format = msg.Text
+		}
+	}
+
+	if len(args) == 0 {
+		return format
+	}
+
+	return fmt.Sprintf(format, args...)
+}
+
+// lookup finds key in the locale, falling back to English for the plural
+// forms of messages that haven't been translated. It also returns the
+// language of the message found, whose plural rules apply to it.
+func (l *Localizer) lookup(key string) (message, language.Tag, bool) {
+	if l == nil {
+		return message{}, language.Und, false
+	}
+	if msg, ok := l.locale.Messages[key]; ok && (msg.Text != "" || len(msg.Plural) > 0) {
+		return msg, l.locale.tag, true
+	}
+	if msg, ok := l.english.Messages[key]; ok {
+		return msg, l.english.tag, true
+	}
+
+	return message{}, language.Und, false
+}
+
+func pluralForm(tag language.Tag, forms map[string]string, args []any) string {
+	n := 0
+	if len(args) > 0 {
+		n, _ = args[0].(int)
+	}
+
+	form := pluralNames[plural.Cardinal.MatchPlural(tag, n, 0, 0, 0, 0)]
+	if f, ok := forms[form]; ok {
+		return f
+	}
+
+	return forms["other"]
+}
+
+var pluralNames = map[plural.Form]string{
+	plural.Other: "other",
+	plural.Zero:  "zero",
+	plural.One:   "one",
+	plural.Two:   "two",
+	plural.Few:   "few",
+	plural.Many:  "many",
+}
+
+// Date formats t with the locale's date layout and month names.
+func (l *Localizer) Date(t time.Time) string {
+	if l == nil || l.locale.Dates.Layout == "" {
+		return t.Format(defaultDateLayout)
+	}
+
+	dates := l.locale.Dates
+	layout := dates.Layout
+	// Month names are swapped into the layout before formatting, long
+	// names first as "Jan" is a prefix of "January".
+	if len(dates.Months) == 12 {
+		layout = strings.ReplaceAll(layout, "January", dates.Months[t.Month()-1])
+	}
+	if len(dates.ShortMonths) == 12 {
+		layout = strings.ReplaceAll(layout, "Jan", dates.ShortMonths[t.Month()-1])
+	}
+
+	return t.Format(layout)
+}
@@ -0,0 +1,9 @@
+package locales
+
+import "embed"
+
+// FS holds a JSON message catalog for each locale, in the format described
+// by package i18n.
+//
+//go:embed *.json
+var FS embed.FS
@@ -0,0 +1,177 @@
+package markdown
+
+import (
+	"html"
+	"html/template"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	headingRe  = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	bulletRe   = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	numberedRe = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+	linkRe     = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	codeRe     = regexp.MustCompile("`([^`]+)`")
+	strongRe   = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	emphasisRe = regexp.MustCompile(`\*([^*]+)\*|\b_([^_]+)_\b`)
+	tokenRe    = regexp.MustCompile("\x00(\\d+)\x00")
+)
+
+// Render converts a small subset of Markdown (paragraphs, headings, lists,
+// emphasis, inline code and links) to HTML. The source is escaped before
+// any markup is added, so raw HTML is never passed through, and links are
+// only created for http, https and mailto URLs.
+func Render(src string) template.HTML {
+	var sb strings.Builder
+	var paragraph []string
+	var listTag string
+
+	flushParagraph := func() {
+		if len(paragraph) == 0 {
+			return
+		}
+		sb.WriteString("<p>")
+		sb.WriteString(strings.Join(paragraph, "<br>\n"))
+		sb.WriteString("</p>\n")
+		paragraph = nil
+	}
+	closeList := func() {
+		if listTag == "" {
+			return
+		}
+		sb.WriteString("</" + listTag + ">\n")
+		listTag = ""
+	}
+	openList := func(tag string) {
+		if listTag == tag {
+			return
+		}
+		closeList()
+		sb.WriteString("<" + tag + ">\n")
+		listTag = tag
+	}
+
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	for _, line := range strings.Split(src, "\n") {
+		if strings.TrimSpace(line) == "" {
+			flushParagraph()
+			closeList()
+			continue
+		}
+
+		if m := headingRe.FindStringSubmatch(line); m != nil {
+			flushParagraph()
+			closeList()
+			tag := "h" + string(rune('0'+len(m[1])))
+			sb.WriteString("<" + tag + ">" + inline(m[2]) + "</" + tag + ">\n")
+			continue
+		}
+
+		if m := bulletRe.FindStringSubmatch(line); m != nil {
+			flushParagraph()
+			openList("ul")
+			sb.WriteString("<li>" + inline(m[1]) + "</li>\n")
+			continue
+		}
+
+		if m := numberedRe.FindStringSubmatch(line); m != nil {
+			flushParagraph()
+			openList("ol")
+			sb.WriteString("<li>" + inline(m[1]) + "</li>\n")
+			continue
+		}
+
+		closeList()
+		paragraph = append(paragraph, inline(strings.TrimSpace(line)))
+	}
+	flushParagraph()
+	closeList()
+
+	return template.HTML(sb.String())
+}
+
+// Excerpt returns the text of src without Markdown syntax, shortened to at
+// most n characters.
+func Excerpt(src string, n int) string {
+	var words []string
+	for _, line := range strings.Split(src, "\n") {
+		line = strings.TrimSpace(line)
+		if m := headingRe.FindStringSubmatch(line); m != nil {
+			line = m[2]
+		} else if m := bulletRe.FindStringSubmatch(line); m != nil {
+			line = m[1]
+		} else if m := numberedRe.FindStringSubmatch(line); m != nil {
+			line = m[1]
+		}
+		line = linkRe.ReplaceAllString(line, "$1")
+		line = strings.NewReplacer("**", "", "__", "", "*", "", "`", "").Replace(line)
+		words = append(words, strings.Fields(line)...)
+	}
+
+	text := strings.Join(words, " ")
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+
+	return strings.TrimSpace(string(runes[:n])) + "…"
+}
+
+func inline(text string) string {
+	// Code spans and links are swapped out for placeholders before the text
+	// is escaped, so their contents aren't treated as emphasis and the
+	// markup we generate isn't escaped.
+	var tokens []string
+	placeholder := func(html string) string {
+		tokens = append(tokens, html)
+		return "\x00" + strconv.Itoa(len(tokens)-1) + "\x00"
+	}
+
+	expand := func(text string) string {
+		return tokenRe.ReplaceAllStringFunc(text, func(m string) string {
+			i, err := strconv.Atoi(m[1 : len(m)-1])
+			if err != nil || i >= len(tokens) {
+				return ""
+			}
+			return tokens[i]
+		})
+	}
+
+	text = strings.ReplaceAll(text, "\x00", "")
+	text = codeRe.ReplaceAllStringFunc(text, func(m string) string {
+		return placeholder("<code>" + html.EscapeString(m[1:len(m)-1]) + "</code>")
+	})
+	text = linkRe.ReplaceAllStringFunc(text, func(m string) string {
+		parts := linkRe.FindStringSubmatch(m)
+		// The label may contain code spans.
+		label := expand(html.EscapeString(parts[1]))
+		href, ok := safeURL(parts[2])
+		if !ok {
+			return placeholder(label)
+		}
+		return placeholder(`<a href="` + html.EscapeString(href) + `" rel="nofollow noopener">` + label + "</a>")
+	})
+
+	text = html.EscapeString(text)
+	text = strongRe.ReplaceAllString(text, "<strong>$1$2</strong>")
+	text = emphasisRe.ReplaceAllString(text, "<em>$1$2</em>")
+
+	return expand(text)
+}
+
+func safeURL(raw string) (string, bool) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", false
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "mailto":
+		return u.String(), true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,189 @@
+package markdown
+
+import (
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "paragraph",
+			src:  "**a** and *b*",
+			want: "<p><strong>a</strong> and <em>b</em></p>\n",
+		},
+		{
+			name: "heading",
+			src:  "## Title",
+			want: "<h2>Title</h2>\n",
+		},
+		{
+			name: "list",
+			src:  "- one\n- two",
+			want: "<ul>\n<li>one</li>\n<li>two</li>\n</ul>\n",
+		},
+		{
+			name: "http link",
+			src:  "[x](https://example.com/a)",
+			want: `<p><a href="https://example.com/a" rel="nofollow noopener">x</a></p>` + "\n",
+		},
+		{
+			name: "mailto link",
+			src:  "[x](mailto:a@example.com)",
+			want: `<p><a href="mailto:a@example.com" rel="nofollow noopener">x</a></p>` + "\n",
+		},
+		{
+			name: "query string is escaped",
+			src:  "[x](http://a?b=1&c=2)",
+			want: `<p><a href="http://a?b=1&amp;c=2" rel="nofollow noopener">x</a></p>` + "\n",
+		},
+		{
+			name: "javascript scheme",
+			src:  "[x](javascript:alert(1))",
+			want: "<p>x)</p>\n",
+		},
+		{
+			name: "javascript scheme in mixed case",
+			src:  "[x](JaVaScRiPt:alert(1))",
+			want: "<p>x)</p>\n",
+		},
+		{
+			name: "data scheme",
+			src:  "[x](data:text/html;base64,PHNjcmlwdD4=)",
+			want: "<p>x</p>\n",
+		},
+		{
+			name: "decimal entity in scheme",
+			src:  "[x](&#106;avascript:alert(1))",
+			want: "<p>x)</p>\n",
+		},
+		{
+			name: "hex entity in scheme",
+			src:  "[x](&#x6A;avascript:alert(1))",
+			want: "<p>x)</p>\n",
+		},
+		{
+			name: "entity for colon",
+			src:  "[x](javascript&#58;alert(1))",
+			want: "<p>x)</p>\n",
+		},
+		{
+			name: "percent-encoded scheme",
+			src:  "[x](java%73cript:alert(1))",
+			want: "<p>x)</p>\n",
+		},
+		{
+			name: "protocol-relative URL",
+			src:  "[x](//evil.example)",
+			want: "<p>x</p>\n",
+		},
+		{
+			name: "link inside link",
+			src:  "[a [b](http://x)](javascript:alert(1))",
+			want: `<p><a href="http://x" rel="nofollow noopener">a [b</a>](javascript:alert(1))</p>` + "\n",
+		},
+		{
+			name: "image syntax",
+			src:  "![alt](http://x/a.png)",
+			want: `<p>!<a href="http://x/a.png" rel="nofollow noopener">alt</a></p>` + "\n",
+		},
+		{
+			name: "image with javascript scheme",
+			src:  "![alt](javascript:alert(1))",
+			want: "<p>!alt)</p>\n",
+		},
+		{
+			name: "code in link label",
+			src:  "[`code`](http://x)",
+			want: `<p><a href="http://x" rel="nofollow noopener"><code>code</code></a></p>` + "\n",
+		},
+		{
+			name: "script tag",
+			src:  "<script>alert(1)</script>",
+			want: "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n",
+		},
+		{
+			name: "event handler attribute",
+			src:  "<img src=x onerror=alert(1)>",
+			want: "<p>&lt;img src=x onerror=alert(1)&gt;</p>\n",
+		},
+		{
+			name: "raw anchor",
+			src:  `<a href="javascript:alert(1)">x</a>`,
+			want: "<p>&lt;a href=&#34;javascript:alert(1)&#34;&gt;x&lt;/a&gt;</p>\n",
+		},
+		{
+			name: "raw HTML in heading",
+			src:  "# <i>h</i>",
+			want: "<h1>&lt;i&gt;h&lt;/i&gt;</h1>\n",
+		},
+		{
+			name: "raw HTML in code",
+			src:  "`<b>`",
+			want: "<p><code>&lt;b&gt;</code></p>\n",
+		},
+		{
+			name: "double quote in URL",
+			src:  `[x](http://a/"onmouseover="alert(1))`,
+			want: `<p><a href="http://a/%22onmouseover=%22alert%281" rel="nofollow noopener">x</a>)</p>` + "\n",
+		},
+		{
+			name: "single quote in URL",
+			src:  "[x](http://a/'onmouseover='alert(1))",
+			want: `<p><a href="http://a/&#39;onmouseover=&#39;alert(1" rel="nofollow noopener">x</a>)</p>` + "\n",
+		},
+		{
+			name: "quote in label",
+			src:  `[x" onclick="y](http://a)`,
+			want: `<p><a href="http://a" rel="nofollow noopener">x&#34; onclick=&#34;y</a></p>` + "\n",
+		},
+		{
+			name: "placeholder bytes in source",
+			src:  "a\x000\x00b",
+			want: "<p>a0b</p>\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(Render(tt.src))
+			if got != tt.want {
+				t.Errorf("Render(%q) = %q, want %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		n    int
+		want string
+	}{
+		{
+			name: "markup is removed",
+			src:  "# Title\n\n- **one** [link](http://x)",
+			n:    100,
+			want: "Title one link",
+		},
+		{
+			name: "shortened",
+			src:  "one two three",
+			n:    7,
+			want: "one two…",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Excerpt(tt.src, tt.n)
+			if got != tt.want {
+				t.Errorf("Excerpt(%q, %d) = %q, want %q", tt.src, tt.n, got, tt.want)
+			}
+		})
+	}
+}
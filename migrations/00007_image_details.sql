@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE images
+    ADD COLUMN position INT NOT NULL DEFAULT 0,
+    ADD COLUMN caption TEXT NOT NULL DEFAULT '',
+    ADD COLUMN alt_text TEXT NOT NULL DEFAULT '';
+
+UPDATE images SET position = id;
+
+ALTER TABLE galleries
+    ADD COLUMN cover_image_id INT REFERENCES images (id) ON DELETE SET NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE galleries
+    DROP COLUMN cover_image_id;
+
+ALTER TABLE images
+    DROP COLUMN position,
+    DROP COLUMN caption,
+    DROP COLUMN alt_text;
+-- +goose StatementEnd
@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE galleries
+    ADD COLUMN description TEXT NOT NULL DEFAULT '',
+    ADD COLUMN public BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN search tsvector NOT NULL DEFAULT ''::tsvector;
+
+CREATE TABLE gallery_tags (
+    gallery_id INT NOT NULL REFERENCES galleries (id) ON DELETE CASCADE,
+    tag TEXT NOT NULL,
+    PRIMARY KEY (gallery_id, tag)
+);
+
+CREATE INDEX gallery_tags_tag_idx ON gallery_tags (tag);
+CREATE INDEX galleries_search_idx ON galleries USING GIN (search);
+
+UPDATE galleries
+SET search =
+    setweight(to_tsvector('english', coalesce(galleries.title, '')), 'A') ||
+    setweight(to_tsvector('english', (
+        SELECT coalesce(string_agg(images.caption, ' '), '')
+        FROM images
+        WHERE images.gallery_id = galleries.id
+    )), 'C');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE gallery_tags;
+
+ALTER TABLE galleries
+    DROP COLUMN description,
+    DROP COLUMN public,
+    DROP COLUMN search;
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+UPDATE galleries SET title = '' WHERE title IS NULL;
+
+ALTER TABLE galleries
+    ALTER COLUMN title SET DEFAULT '',
+    ALTER COLUMN title SET NOT NULL,
+    ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE INDEX galleries_user_created_idx ON galleries (user_id, created_at, id);
+CREATE INDEX galleries_user_updated_idx ON galleries (user_id, updated_at, id);
+CREATE INDEX galleries_user_title_idx ON galleries (user_id, lower(title), id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE galleries
+    DROP COLUMN created_at,
+    DROP COLUMN updated_at,
+    ALTER COLUMN title DROP NOT NULL,
+    ALTER COLUMN title DROP DEFAULT;
+-- +goose StatementEnd
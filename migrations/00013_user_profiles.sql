@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users
+    ADD COLUMN username TEXT UNIQUE,
+    ADD COLUMN display_name TEXT NOT NULL DEFAULT '',
+    ADD COLUMN bio TEXT NOT NULL DEFAULT '',
+    ADD COLUMN avatar TEXT NOT NULL DEFAULT '';
+
+-- Old usernames keep working as links to the profile of the user who gave
+-- them up, and can't be taken by anyone else.
+CREATE TABLE username_redirects (
+    username TEXT PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE username_redirects;
+
+ALTER TABLE users
+    DROP COLUMN username,
+    DROP COLUMN display_name,
+    DROP COLUMN bio,
+    DROP COLUMN avatar;
+-- +goose StatementEnd
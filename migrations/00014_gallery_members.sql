@@ -0,0 +1,48 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE gallery_members (
+    gallery_id INT NOT NULL REFERENCES galleries (id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role TEXT NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (gallery_id, user_id)
+);
+
+CREATE INDEX gallery_members_user_id_idx ON gallery_members (user_id);
+
+INSERT INTO gallery_members (gallery_id, user_id, role)
+SELECT id, user_id, 'owner'
+FROM galleries
+WHERE user_id IS NOT NULL;
+
+CREATE TABLE gallery_invitations (
+    id SERIAL PRIMARY KEY,
+    gallery_id INT NOT NULL REFERENCES galleries (id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    role TEXT NOT NULL CHECK (role IN ('editor', 'viewer')),
+    invited_by INT REFERENCES users (id) ON DELETE SET NULL,
+    token_hash TEXT UNIQUE NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (gallery_id, email)
+);
+
+ALTER TABLE images
+    ADD COLUMN uploaded_by INT REFERENCES users (id) ON DELETE SET NULL;
+
+-- Until now only the owner could upload.
+UPDATE images
+SET uploaded_by = galleries.user_id
+FROM galleries
+WHERE galleries.id = images.gallery_id;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE images
+    DROP COLUMN uploaded_by;
+
+DROP TABLE gallery_invitations;
+
+DROP TABLE gallery_members;
+-- +goose StatementEnd
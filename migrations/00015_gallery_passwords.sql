@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE galleries
+    ADD COLUMN password_hash TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE gallery_password_failures (
+    id SERIAL PRIMARY KEY,
+    gallery_id INT NOT NULL REFERENCES galleries (id) ON DELETE CASCADE,
+    client TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX gallery_password_failures_gallery_id_client_idx ON gallery_password_failures (gallery_id, client, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE gallery_password_failures;
+
+ALTER TABLE galleries
+    DROP COLUMN password_hash;
+-- +goose StatementEnd
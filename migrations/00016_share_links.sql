@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE share_links (
+    id SERIAL PRIMARY KEY,
+    gallery_id INT NOT NULL REFERENCES galleries (id) ON DELETE CASCADE,
+    label TEXT NOT NULL DEFAULT '',
+    token_hash TEXT UNIQUE NOT NULL,
+    allow_downloads BOOLEAN NOT NULL DEFAULT false,
+    views INT NOT NULL DEFAULT 0,
+    downloads INT NOT NULL DEFAULT 0,
+    created_by INT REFERENCES users (id) ON DELETE SET NULL,
+    expires_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX share_links_gallery_id_idx ON share_links (gallery_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE share_links;
+-- +goose StatementEnd
@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE selections (
+    id SERIAL PRIMARY KEY,
+    gallery_id INT NOT NULL REFERENCES galleries (id) ON DELETE CASCADE,
+    user_id INT REFERENCES users (id) ON DELETE SET NULL,
+    token_hash TEXT UNIQUE NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    comment TEXT NOT NULL DEFAULT '',
+    submitted_at TIMESTAMPTZ,
+    -- The client, such as an IP address, that submitted the selection, so
+    -- that clients can be limited to a few submissions at a time.
+    client TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX selections_gallery_id_idx ON selections (gallery_id);
+CREATE INDEX selections_gallery_id_client_idx ON selections (gallery_id, client, submitted_at);
+
+CREATE TABLE selection_images (
+    selection_id INT NOT NULL REFERENCES selections (id) ON DELETE CASCADE,
+    image_id INT NOT NULL REFERENCES images (id) ON DELETE CASCADE,
+    note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (selection_id, image_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE selection_images;
+
+DROP TABLE selections;
+-- +goose StatementEnd
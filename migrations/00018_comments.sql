@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE galleries
+    ADD COLUMN comments TEXT NOT NULL DEFAULT 'open' CHECK (comments IN ('open', 'moderated', 'off'));
+
+CREATE TABLE comments (
+    id SERIAL PRIMARY KEY,
+    gallery_id INT NOT NULL REFERENCES galleries (id) ON DELETE CASCADE,
+    image_id INT REFERENCES images (id) ON DELETE CASCADE,
+    parent_id INT REFERENCES comments (id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    body TEXT NOT NULL,
+    approved BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX comments_gallery_id_image_id_idx ON comments (gallery_id, image_id);
+CREATE INDEX comments_user_id_created_at_idx ON comments (user_id, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE comments;
+
+ALTER TABLE galleries
+    DROP COLUMN comments;
+-- +goose StatementEnd
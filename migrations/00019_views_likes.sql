@@ -0,0 +1,48 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE galleries
+    ADD COLUMN views BIGINT NOT NULL DEFAULT 0;
+
+ALTER TABLE images
+    ADD COLUMN views BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE gallery_daily_views (
+    gallery_id INT NOT NULL REFERENCES galleries (id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    views INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (gallery_id, day)
+);
+
+CREATE INDEX gallery_daily_views_day_idx ON gallery_daily_views (day);
+
+CREATE TABLE gallery_likes (
+    gallery_id INT NOT NULL REFERENCES galleries (id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (gallery_id, user_id)
+);
+
+CREATE INDEX gallery_likes_created_at_idx ON gallery_likes (created_at);
+
+CREATE TABLE image_likes (
+    image_id INT NOT NULL REFERENCES images (id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (image_id, user_id)
+);
+
+CREATE INDEX image_likes_created_at_idx ON image_likes (created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE image_likes;
+DROP TABLE gallery_likes;
+DROP TABLE gallery_daily_views;
+
+ALTER TABLE images
+    DROP COLUMN views;
+
+ALTER TABLE galleries
+    DROP COLUMN views;
+-- +goose StatementEnd
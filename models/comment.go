@@ -0,0 +1,347 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// CommentMode is whether a gallery takes comments.
+type CommentMode string
+
+const (
+	CommentsOpen CommentMode = "open"
+	// CommentsModerated hides comments from people who aren't members
+	// until the owner approves them.
+	CommentsModerated CommentMode = "moderated"
+	// CommentsOff hides every comment and takes no new ones.
+	CommentsOff CommentMode = "off"
+)
+
+func (m CommentMode) Valid() bool {
+	return m == CommentsOpen || m == CommentsModerated || m == CommentsOff
+}
+
+const (
+	// MaxCommentsPerWindow is how many comments a user can post within
+	// CommentWindow, across all galleries.
+	MaxCommentsPerWindow = 10
+	CommentWindow        = 10 * time.Minute
+
+	// MaxCommentDepth is how deeply replies can be nested. Comments that
+	// aren't replies are at depth zero.
+	MaxCommentDepth = 4
+)
+
+type Comment struct {
+	ID        int
+	GalleryID int
+	// ImageID is zero for comments on the gallery itself.
+	ImageID int
+	// ParentID is the comment this one replies to, or zero.
+	ParentID int
+	UserID   int
+	Body     string
+	Approved bool
+	// AuthorName and AuthorUsername are empty if the author has neither a
+	// display name nor a username.
+	AuthorName     string
+	AuthorUsername string
+	CreatedAt      time.Time
+}
+
+type CommentService struct {
+	DB *sql.DB
+}
+
+// Create posts a comment. A reply must be on the same gallery or image as
+// the comment it replies to, and that comment must be one the author can
+// see: it is approved, theirs, or they moderate the gallery. Users who have
+// posted MaxCommentsPerWindow comments in the last CommentWindow get
+// ErrTooManyComments, and replies nested MaxCommentDepth deep get
+// ErrCommentTooDeep.
+func (service *CommentService) Create(comment *Comment, moderator bool) error {
+	tx, err := service.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("create comment: %w", err)
+	}
+	defer tx.Rollback()
+
+	// The lock makes concurrent comments by the user wait, so that they
+	// can't all get in under the limit.
+	_, err = tx.Exec(
+		`
+		SELECT pg_advisory_xact_lock(hashtext('comments'), $1);`,
+		comment.UserID,
+	)
+	if err != nil {
+		return fmt.Errorf("create comment: %w", err)
+	}
+
+	if comment.ParentID != 0 {
+		var imageID, authorID int
+		var approved bool
+		err = tx.QueryRow(
+			`
+			SELECT COALESCE(image_id, 0), user_id, approved
+			FROM comments
+			WHERE id = $1 AND gallery_id = $2;`,
+			comment.ParentID,
+			comment.GalleryID,
+		).Scan(&imageID, &authorID, &approved)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("create comment: %w", ErrNotFound)
+			}
+			return fmt.Errorf("create comment: %w", err)
+		}
+		if imageID != comment.ImageID {
+			return fmt.Errorf("create comment: %w", ErrNotFound)
+		}
+		if !approved && authorID != comment.UserID && !moderator {
+			return fmt.Errorf("create comment: %w", ErrNotFound)
+		}
+
+		// The parent's depth is how many comments it replies to in turn.
+		var depth int
+		err = tx.QueryRow(
+			`
+			WITH RECURSIVE ancestors AS (
+				SELECT parent_id, 0 AS depth
+				FROM comments
+				WHERE id = $1
+				UNION ALL
+				SELECT comments.parent_id, ancestors.depth + 1
+				FROM comments
+				JOIN ancestors ON comments.id = ancestors.parent_id
+			)
+			SELECT max(depth) FROM ancestors;`,
+			comment.ParentID,
+		).Scan(&depth)
+		if err != nil {
+			return fmt.Errorf("create comment: %w", err)
+		}
+		if depth+1 >= MaxCommentDepth {
+			return fmt.Errorf("create comment: %w", ErrCommentTooDeep)
+		}
+	}
+
+	var recent int
+	err = tx.QueryRow(
+		`
+		SELECT count(*)
+		FROM comments
+		WHERE user_id = $1 AND created_at > $2;`,
+		comment.UserID,
+		time.Now().Add(-CommentWindow),
+	).Scan(&recent)
+	if err != nil {
+		return fmt.Errorf("create comment: %w", err)
+	}
+	if recent >= MaxCommentsPerWindow {
+		return fmt.Errorf("create comment: %w", ErrTooManyComments)
+	}
+
+	row := tx.QueryRow(
+		`
+		INSERT INTO comments (gallery_id, image_id, parent_id, user_id, body, approved)
+		VALUES ($1, NULLIF($2, 0), NULLIF($3, 0), $4, $5, $6) RETURNING id, created_at;`,
+		comment.GalleryID,
+		comment.ImageID,
+		comment.ParentID,
+		comment.UserID,
+		comment.Body,
+		comment.Approved,
+	)
+	err = row.Scan(&comment.ID, &comment.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("create comment: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("create comment: %w", err)
+	}
+
+	return nil
+}
+
+// ByGallery lists the comments on a gallery, or on one of its images when
+// imageID isn't zero, oldest first. Comments that haven't been approved are
+// included; it is up to the caller who sees them.
+func (service *CommentService) ByGallery(galleryID, imageID int) ([]Comment, error) {
+	rows, err := service.DB.Query(
+		`
+		SELECT comments.id, COALESCE(comments.parent_id, 0), comments.user_id, comments.body,
+			comments.approved, comments.created_at,
+			COALESCE(NULLIF(users.display_name, ''), users.username, ''), COALESCE(users.username, '')
+		FROM comments
+			JOIN users ON users.id = comments.user_id
+		WHERE comments.gallery_id = $1 AND COALESCE(comments.image_id, 0) = $2
+		ORDER BY comments.created_at, comments.id;`,
+		galleryID,
+		imageID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("comments: %w", err)
+	}
+	defer rows.Close()
+
+	var comments []Comment
+	for rows.Next() {
+		comment := Comment{
+			GalleryID: galleryID,
+			ImageID:   imageID,
+		}
+		err = rows.Scan(&comment.ID, &comment.ParentID, &comment.UserID, &comment.Body,
+			&comment.Approved, &comment.CreatedAt,
+			&comment.AuthorName, &comment.AuthorUsername)
+		if err != nil {
+			return nil, fmt.Errorf("comments: %w", err)
+		}
+		comments = append(comments, comment)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("comments: %w", rows.Err())
+	}
+
+	return comments, nil
+}
+
+// ImageCounts returns the number of approved comments on each image of the
+// gallery that has any.
+func (service *CommentService) ImageCounts(galleryID int) (map[int]int, error) {
+	rows, err := service.DB.Query(
+		`
+		SELECT image_id, count(*)
+		FROM comments
+		WHERE gallery_id = $1 AND image_id IS NOT NULL AND approved
+		GROUP BY image_id;`,
+		galleryID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("comment counts: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[int]int)
+	for rows.Next() {
+		var imageID, count int
+		err = rows.Scan(&imageID, &count)
+		if err != nil {
+			return nil, fmt.Errorf("comment counts: %w", err)
+		}
+		counts[imageID] = count
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("comment counts: %w", rows.Err())
+	}
+
+	return counts, nil
+}
+
+func (service *CommentService) ByID(galleryID, id int) (*Comment, error) {
+	comment := Comment{
+		ID:        id,
+		GalleryID: galleryID,
+	}
+
+	row := service.DB.QueryRow(
+		`
+		SELECT COALESCE(image_id, 0), COALESCE(parent_id, 0), user_id, body, approved, created_at
+		FROM comments
+		WHERE id = $1 AND gallery_id = $2;`,
+		comment.ID,
+		comment.GalleryID,
+	)
+	err := row.Scan(&comment.ImageID, &comment.ParentID, &comment.UserID, &comment.Body, &comment.Approved, &comment.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("comment by id: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("comment by id: %w", err)
+	}
+
+	return &comment, nil
+}
+
+// Approve shows a comment held for moderation to everyone.
+func (service *CommentService) Approve(galleryID, id int) error {
+	result, err := service.DB.Exec(
+		`
+		UPDATE comments
+		SET approved = true
+		WHERE id = $1 AND gallery_id = $2;`,
+		id,
+		galleryID,
+	)
+	if err != nil {
+		return fmt.Errorf("approve comment: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("approve comment: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("approve comment: %w", ErrNotFound)
+	}
+
+	return nil
+}
+
+// Delete removes a comment along with every reply to it.
+func (service *CommentService) Delete(galleryID, id int) error {
+	result, err := service.DB.Exec(
+		`
+		DELETE FROM comments
+		WHERE id = $1 AND gallery_id = $2;`,
+		id,
+		galleryID,
+	)
+	if err != nil {
+		return fmt.Errorf("delete comment: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("delete comment: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("delete comment: %w", ErrNotFound)
+	}
+
+	return nil
+}
+
+// SetCommentMode changes whether the gallery takes comments.
+func (service *GalleryService) SetCommentMode(galleryID int, mode CommentMode) error {
+	if !mode.Valid() {
+		return fmt.Errorf("set comment mode: invalid mode %q", mode)
+	}
+
+	result, err := service.DB.Exec(
+		`
+		UPDATE galleries
+		SET comments = $2
+		WHERE id = $1;`,
+		galleryID,
+		mode,
+	)
+	if err != nil {
+		return fmt.Errorf("set comment mode: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("set comment mode: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("set comment mode: %w", ErrNotFound)
+	}
+
+	return nil
+}
@@ -1,13 +1,29 @@
 package models
 
 import (
+	"bytes"
+	"database/sql"
+	"errors"
 	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"strings"
+	texttemplate "text/template"
+	"time"
 
-	"github.com/go-mail/mail/v2"
+	"github.com/IrakliGiorgadze/go-web-app/emails"
+	"golang.org/x/text/language"
 )
 
 const (
 	DefaultSender = "support@lumon.com"
+	DefaultLocale = "en"
+)
+
+const (
+	EmailPending = "pending"
+	EmailSent    = "sent"
+	EmailFailed  = "failed"
 )
 
 type Email struct {
@@ -27,68 +43,350 @@ type SMTPConfig struct {
 
 type EmailService struct {
 	DefaultSender string
-	dialer        *mail.Dialer
+	// Templates holds the email templates, laid out as described in the
+	// emails package. It defaults to emails.FS.
+	Templates fs.FS
+	// DB and Jobs store emails in the outbox and send them in the
+	// background. Emails are sent straight away when either is nil.
+	DB        *sql.DB
+	Jobs      *JobService
+	Transport Transport
 }
 
-func NewEmailService(config SMTPConfig) *EmailService {
+func NewEmailService(transport Transport) *EmailService {
 	es := EmailService{
-		dialer: mail.NewDialer(config.Host, config.Port, config.Username, config.Password),
+		Transport: transport,
 	}
 
 	return &es
 }
 
 func (es *EmailService) Send(email Email) error {
-	msg := mail.NewMessage()
-	msg.SetHeader("To", email.To)
-	es.setFrom(msg, email)
-	msg.SetHeader("Subject", email.Subject)
+	email.From = es.sender(email)
 
-	switch {
-	case email.Plaintext != "" && email.HTML != "":
-		msg.SetBody("text/plain", email.Plaintext)
-		msg.AddAlternative("text/html", email.HTML)
-	case email.Plaintext != "":
-		msg.SetBody("text/plain", email.Plaintext)
-	case email.HTML != "":
-		msg.SetBody("text/html", email.HTML)
+	err := es.Transport.Send(email)
+	if err != nil {
+		return fmt.Errorf("send: %w", err)
 	}
 
-	err := es.dialer.DialAndSend(msg)
+	return nil
+}
+
+// ForgotPassword sends the password reset link. The locale may be a single
+// language tag or an Accept-Language header.
+func (es *EmailService) ForgotPassword(to, locale, resetURL string) error {
+	err := es.sendTemplate("forgot-pw", to, locale, struct {
+		ResetURL string
+	}{
+		ResetURL: resetURL,
+	})
 	if err != nil {
-		return fmt.Errorf("send: %w", err)
+		return fmt.Errorf("forgot password email: %w", err)
 	}
 
 	return nil
 }
 
-func (es *EmailService) ForgotPassword(to, resetURL string) error {
-	email := Email{
-		To:        to,
-		Subject:   "Reset your password",
-		Plaintext: "To reset your password, please visit the following link: " + resetURL,
-		HTML:      `<p>To reset your password, please visit the following link: <a href="` + resetURL + `">` + resetURL + `</a></p>`,
+// Welcome is sent when someone signs up, and AccountExists when they sign
+// up with an address that already has an account. Both point at the sign
+// in page, so that the sign up page can look the same either way.
+func (es *EmailService) Welcome(to, locale, signInURL, resetURL string) error {
+	err := es.sendTemplate("welcome", to, locale, struct {
+		SignInURL string
+		ResetURL  string
+	}{
+		SignInURL: signInURL,
+		ResetURL:  resetURL,
+	})
+	if err != nil {
+		return fmt.Errorf("welcome email: %w", err)
 	}
 
-	err := es.Send(email)
+	return nil
+}
+
+func (es *EmailService) AccountExists(to, locale, signInURL, resetURL string) error {
+	err := es.sendTemplate("account-exists", to, locale, struct {
+		SignInURL string
+		ResetURL  string
+	}{
+		SignInURL: signInURL,
+		ResetURL:  resetURL,
+	})
 	if err != nil {
-		return fmt.Errorf("forgot password email: %w", err)
+		return fmt.Errorf("account exists email: %w", err)
 	}
 
 	return nil
 }
 
-func (es *EmailService) setFrom(msg *mail.Message, email Email) {
-	var from string
+// GalleryInvitation sends the link that makes the recipient a member of a
+// gallery.
+func (es *EmailService) GalleryInvitation(to, locale, inviterName, galleryTitle string, role Role, acceptURL string) error {
+	err := es.sendTemplate("gallery-invitation", to, locale, struct {
+		InviterName  string
+		GalleryTitle string
+		Role         Role
+		AcceptURL    string
+	}{
+		InviterName:  inviterName,
+		GalleryTitle: galleryTitle,
+		Role:         role,
+		AcceptURL:    acceptURL,
+	})
+	if err != nil {
+		return fmt.Errorf("gallery invitation email: %w", err)
+	}
+
+	return nil
+}
+
+// SelectionSubmitted tells the owner of a gallery that a visitor has sent
+// them a selection of favourite images.
+func (es *EmailService) SelectionSubmitted(to, locale, galleryTitle string, selection *Selection, selectionsURL string) error {
+	err := es.sendTemplate("selection-submitted", to, locale, struct {
+		GalleryTitle  string
+		Name          string
+		Comment       string
+		Count         int
+		SelectionsURL string
+	}{
+		GalleryTitle:  galleryTitle,
+		Name:          selection.Name,
+		Comment:       selection.Comment,
+		Count:         len(selection.Images),
+		SelectionsURL: selectionsURL,
+	})
+	if err != nil {
+		return fmt.Errorf("selection submitted email: %w", err)
+	}
+
+	return nil
+}
 
+func (es *EmailService) sendTemplate(name, to, locale string, data any) error {
+	email, err := es.render(name, locale, data)
+	if err != nil {
+		return err
+	}
+	email.To = to
+
+	return es.deliver(email)
+}
+
+// SendJob handles JobSendEmail jobs, sending one email from the outbox.
+func (es *EmailService) SendJob(job *Job) error {
+	var payload struct {
+		ID int
+	}
+	err := job.Decode(&payload)
+	if err != nil {
+		return err
+	}
+
+	return es.sendOutbox(payload.ID, job.Attempts >= job.MaxAttempts)
+}
+
+// deliver stores the email in the outbox and queues a job to send it, in
+// one transaction so that neither exists without the other.
+func (es *EmailService) deliver(email Email) error {
+	if es.DB == nil || es.Jobs == nil {
+		return es.Send(email)
+	}
+
+	tx, err := es.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("queue email: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int
+	row := tx.QueryRow(
+		`
+		INSERT INTO email_outbox (recipient, sender, subject, plaintext, html)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id;`,
+		email.To,
+		es.sender(email),
+		email.Subject,
+		email.Plaintext,
+		email.HTML,
+	)
+	err = row.Scan(&id)
+	if err != nil {
+		return fmt.Errorf("queue email: %w", err)
+	}
+
+	err = es.Jobs.schedule(tx, JobSendEmail, "", struct{ ID int }{id}, time.Time{})
+	if err != nil {
+		return fmt.Errorf("queue email: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("queue email: %w", err)
+	}
+
+	return nil
+}
+
+// sendOutbox sends an email from the outbox and records the outcome. The
+// body of a sent email is cleared, as it may hold secrets such as password
+// reset tokens that shouldn't be kept at rest.
+func (es *EmailService) sendOutbox(id int, lastAttempt bool) error {
+	var email Email
+	var status string
+	row := es.DB.QueryRow(
+		`
+		SELECT recipient, sender, subject, plaintext, html, status
+		FROM email_outbox
+		WHERE id = $1;`,
+		id,
+	)
+	err := row.Scan(&email.To, &email.From, &email.Subject, &email.Plaintext, &email.HTML, &status)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return fmt.Errorf("send outbox email: %w", err)
+	}
+
+	if status == EmailSent {
+		return nil
+	}
+
+	sendErr := es.Send(email)
+	if sendErr != nil {
+		status = EmailPending
+		if lastAttempt {
+			status = EmailFailed
+		}
+
+		_, err = es.DB.Exec(
+			`
+			UPDATE email_outbox
+			SET status = $2, attempts = attempts + 1, last_error = $3
+			WHERE id = $1;`,
+			id,
+			status,
+			sendErr.Error(),
+		)
+		if err != nil {
+			return fmt.Errorf("send outbox email: %w", err)
+		}
+
+		return fmt.Errorf("send outbox email: %w", sendErr)
+	}
+
+	_, err = es.DB.Exec(
+		`
+		UPDATE email_outbox
+		SET status = 'sent', attempts = attempts + 1, last_error = '',
+			plaintext = '', html = '', sent_at = now()
+		WHERE id = $1;`,
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("send outbox email: %w", err)
+	}
+
+	return nil
+}
+
+// render executes the templates for the named email in the available locale
+// that best matches locale, falling back to DefaultLocale.
+func (es *EmailService) render(name, locale string, data any) (Email, error) {
+	templates := es.templates()
+
+	dir, err := matchLocale(templates, locale)
+	if err != nil {
+		return Email{}, fmt.Errorf("render %v: %w", name, err)
+	}
+
+	text, err := texttemplate.ParseFS(templates, dir+"/"+name+".txt.gohtml")
+	if err != nil {
+		return Email{}, fmt.Errorf("render %v: %w", name, err)
+	}
+
+	var subject, plaintext bytes.Buffer
+	err = text.ExecuteTemplate(&subject, "subject", data)
+	if err != nil {
+		return Email{}, fmt.Errorf("render %v subject: %w", name, err)
+	}
+	err = text.ExecuteTemplate(&plaintext, "text", data)
+	if err != nil {
+		return Email{}, fmt.Errorf("render %v text: %w", name, err)
+	}
+
+	html, err := htmltemplate.New("").Funcs(htmltemplate.FuncMap{
+		"lang": func() string { return dir },
+	}).ParseFS(templates, "layout.gohtml", dir+"/"+name+".html.gohtml")
+	if err != nil {
+		return Email{}, fmt.Errorf("render %v: %w", name, err)
+	}
+
+	var body bytes.Buffer
+	err = html.ExecuteTemplate(&body, "layout", data)
+	if err != nil {
+		return Email{}, fmt.Errorf("render %v html: %w", name, err)
+	}
+
+	return Email{
+		Subject:   strings.TrimSpace(subject.String()),
+		Plaintext: strings.TrimSpace(plaintext.String()),
+		HTML:      strings.TrimSpace(body.String()),
+	}, nil
+}
+
+func (es *EmailService) templates() fs.FS {
+	if es.Templates == nil {
+		return emails.FS
+	}
+
+	return es.Templates
+}
+
+func (es *EmailService) sender(email Email) string {
 	switch {
 	case email.From != "":
-		from = email.From
+		return email.From
 	case es.DefaultSender != "":
-		from = es.DefaultSender
+		return es.DefaultSender
 	default:
-		from = DefaultSender
+		return DefaultSender
 	}
+}
+
+// matchLocale picks the locale directory that best matches a language tag
+// or Accept-Language header.
+func matchLocale(templates fs.FS, locale string) (string, error) {
+	entries, err := fs.ReadDir(templates, ".")
+	if err != nil {
+		return "", err
+	}
+
+	// The default locale goes first, as the matcher falls back to it.
+	dirs := []string{DefaultLocale}
+	tags := []language.Tag{language.Make(DefaultLocale)}
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == DefaultLocale {
+			continue
+		}
+
+		tag, err := language.Parse(entry.Name())
+		if err != nil {
+			continue
+		}
+		dirs = append(dirs, entry.Name())
+		tags = append(tags, tag)
+	}
+
+	prefs, _, err := language.ParseAcceptLanguage(locale)
+	if err != nil {
+		return DefaultLocale, nil
+	}
+
+	_, i, _ := language.NewMatcher(tags).Match(prefs...)
 
-	msg.SetHeader("From", from)
+	return dirs[i], nil
 }
@@ -0,0 +1,134 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEmailServiceForgotPassword(t *testing.T) {
+	transport := &MemoryTransport{}
+	es := NewEmailService(transport)
+
+	resetURL := "https://example.com/reset-pw?token=abc123"
+	err := es.ForgotPassword("jon@example.com", "en", resetURL)
+	if err != nil {
+		t.Fatalf("ForgotPassword() err = %v", err)
+	}
+
+	sent := transport.Sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d emails, want 1", len(sent))
+	}
+	email := sent[0]
+
+	if email.To != "jon@example.com" {
+		t.Errorf("To = %q, want %q", email.To, "jon@example.com")
+	}
+	if email.From != DefaultSender {
+		t.Errorf("From = %q, want %q", email.From, DefaultSender)
+	}
+	if email.Subject != "Reset your password" {
+		t.Errorf("Subject = %q, want %q", email.Subject, "Reset your password")
+	}
+	if !strings.Contains(email.Plaintext, resetURL) {
+		t.Errorf("Plaintext = %q, want it to contain %q", email.Plaintext, resetURL)
+	}
+	if !strings.Contains(email.HTML, `href="https://example.com/reset-pw?token=abc123"`) {
+		t.Errorf("HTML = %q, want a link to %q", email.HTML, resetURL)
+	}
+}
+
+func TestEmailServiceGalleryInvitation(t *testing.T) {
+	transport := &MemoryTransport{}
+	es := NewEmailService(transport)
+	es.DefaultSender = "noreply@example.com"
+
+	acceptURL := "https://example.com/invitations/accept?token=xyz789"
+	err := es.GalleryInvitation("jon@example.com", "en-US,en;q=0.9", "Ana", "Holidays", RoleEditor, acceptURL)
+	if err != nil {
+		t.Fatalf("GalleryInvitation() err = %v", err)
+	}
+
+	sent := transport.Sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d emails, want 1", len(sent))
+	}
+	email := sent[0]
+
+	if email.To != "jon@example.com" {
+		t.Errorf("To = %q, want %q", email.To, "jon@example.com")
+	}
+	if email.From != "noreply@example.com" {
+		t.Errorf("From = %q, want %q", email.From, "noreply@example.com")
+	}
+	if email.Subject != "Ana invited you to Holidays" {
+		t.Errorf("Subject = %q, want %q", email.Subject, "Ana invited you to Holidays")
+	}
+	if !strings.Contains(email.Plaintext, "add photos to and edit") {
+		t.Errorf("Plaintext = %q, want it to describe the editor role", email.Plaintext)
+	}
+	if !strings.Contains(email.Plaintext, acceptURL) {
+		t.Errorf("Plaintext = %q, want it to contain %q", email.Plaintext, acceptURL)
+	}
+	if !strings.Contains(email.HTML, `href="https://example.com/invitations/accept?token=xyz789"`) {
+		t.Errorf("HTML = %q, want a link to %q", email.HTML, acceptURL)
+	}
+}
+
+func TestEmailServiceLocale(t *testing.T) {
+	transport := &MemoryTransport{}
+	es := NewEmailService(transport)
+
+	err := es.ForgotPassword("jon@example.com", "ka", "https://example.com/reset-pw")
+	if err != nil {
+		t.Fatalf("ForgotPassword() err = %v", err)
+	}
+
+	sent := transport.Sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d emails, want 1", len(sent))
+	}
+	if sent[0].Subject != "პაროლის აღდგენა" {
+		t.Errorf("Subject = %q, want %q", sent[0].Subject, "პაროლის აღდგენა")
+	}
+
+	transport.Reset()
+	if n := len(transport.Sent()); n != 0 {
+		t.Errorf("sent %d emails after Reset, want 0", n)
+	}
+}
+
+func TestEmailServiceSignupEmails(t *testing.T) {
+	transport := &MemoryTransport{}
+	es := NewEmailService(transport)
+
+	signInURL := "https://example.com/signin"
+	resetURL := "https://example.com/forgot-pw"
+	err := es.Welcome("new@example.com", "en", signInURL, resetURL)
+	if err != nil {
+		t.Fatalf("Welcome() err = %v", err)
+	}
+	err = es.AccountExists("taken@example.com", "en", signInURL, resetURL)
+	if err != nil {
+		t.Fatalf("AccountExists() err = %v", err)
+	}
+
+	sent := transport.Sent()
+	if len(sent) != 2 {
+		t.Fatalf("sent %d emails, want 2", len(sent))
+	}
+	for i, to := range []string{"new@example.com", "taken@example.com"} {
+		if sent[i].To != to {
+			t.Errorf("To = %q, want %q", sent[i].To, to)
+		}
+		if !strings.Contains(sent[i].Plaintext, signInURL) {
+			t.Errorf("Plaintext = %q, want it to contain %q", sent[i].Plaintext, signInURL)
+		}
+		if !strings.Contains(sent[i].Plaintext, resetURL) {
+			t.Errorf("Plaintext = %q, want it to contain %q", sent[i].Plaintext, resetURL)
+		}
+	}
+	if sent[0].Subject == sent[1].Subject {
+		t.Errorf("Subject = %q for both emails, want them to differ", sent[0].Subject)
+	}
+}
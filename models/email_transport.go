@@ -0,0 +1,176 @@
+package models
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/IrakliGiorgadze/go-web-app/rand"
+	"github.com/go-mail/mail/v2"
+)
+
+const (
+	TransportSMTP   = "smtp"
+	TransportFile   = "file"
+	TransportStdout = "stdout"
+	TransportMemory = "memory"
+
+	DefaultEmailDir = "sent-emails"
+)
+
+// Transport delivers an email that is ready to go. The From address has
+// already been filled in by the EmailService.
+type Transport interface {
+	Send(email Email) error
+}
+
+// NewTransport builds the transport named by kind, which is one of the
+// Transport constants. The SMTP config is only used by TransportSMTP and dir
+// only by TransportFile.
+func NewTransport(kind, dir string, smtp SMTPConfig) (Transport, error) {
+	switch kind {
+	case TransportSMTP, "":
+		return NewSMTPTransport(smtp), nil
+	case TransportFile:
+		return &FileTransport{Dir: dir}, nil
+	case TransportStdout:
+		return &WriterTransport{W: os.Stdout}, nil
+	case TransportMemory:
+		return &MemoryTransport{}, nil
+	default:
+		return nil, fmt.Errorf("unknown email transport %q", kind)
+	}
+}
+
+type SMTPTransport struct {
+	dialer *mail.Dialer
+}
+
+func NewSMTPTransport(config SMTPConfig) *SMTPTransport {
+	return &SMTPTransport{
+		dialer: mail.NewDialer(config.Host, config.Port, config.Username, config.Password),
+	}
+}
+
+func (t *SMTPTransport) Send(email Email) error {
+	err := t.dialer.DialAndSend(newMessage(email))
+	if err != nil {
+		return fmt.Errorf("smtp: %w", err)
+	}
+
+	return nil
+}
+
+// FileTransport writes each email to its own .eml file, which most mail
+// clients can open. It is meant for development.
+type FileTransport struct {
+	Dir string
+}
+
+func (t *FileTransport) Send(email Email) error {
+	dir := t.Dir
+	if dir == "" {
+		dir = DefaultEmailDir
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("file transport: %w", err)
+	}
+
+	suffix, err := rand.String(6)
+	if err != nil {
+		return fmt.Errorf("file transport: %w", err)
+	}
+	name := time.Now().UTC().Format("20060102T150405.000000000") + "-" + suffix + ".eml"
+
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("file transport: %w", err)
+	}
+	defer f.Close()
+
+	_, err = newMessage(email).WriteTo(f)
+	if err != nil {
+		return fmt.Errorf("file transport: %w", err)
+	}
+
+	return f.Close()
+}
+
+// WriterTransport logs a readable copy of each email to W, such as
+// os.Stdout, instead of sending it.
+type WriterTransport struct {
+	W  io.Writer
+	mu sync.Mutex
+}
+
+func (t *WriterTransport) Send(email Email) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	body := email.Plaintext
+	if body == "" {
+		body = email.HTML
+	}
+
+	_, err := fmt.Fprintf(t.W, "From: %s\nTo: %s\nSubject: %s\n\n%s\n\n", email.From, email.To, email.Subject, body)
+	if err != nil {
+		return fmt.Errorf("writer transport: %w", err)
+	}
+
+	return nil
+}
+
+// MemoryTransport records emails instead of sending them, so that tests can
+// make assertions about what was sent.
+type MemoryTransport struct {
+	mu   sync.Mutex
+	sent []Email
+}
+
+func (t *MemoryTransport) Send(email Email) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sent = append(t.sent, email)
+
+	return nil
+}
+
+// Sent returns a copy of the emails sent so far, oldest first.
+func (t *MemoryTransport) Sent() []Email {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]Email(nil), t.sent...)
+}
+
+func (t *MemoryTransport) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sent = nil
+}
+
+func newMessage(email Email) *mail.Message {
+	msg := mail.NewMessage()
+	msg.SetHeader("To", email.To)
+	msg.SetHeader("From", email.From)
+	msg.SetHeader("Subject", email.Subject)
+
+	switch {
+	case email.Plaintext != "" && email.HTML != "":
+		msg.SetBody("text/plain", email.Plaintext)
+		msg.AddAlternative("text/html", email.HTML)
+	case email.Plaintext != "":
+		msg.SetBody("text/plain", email.Plaintext)
+	case email.HTML != "":
+		msg.SetBody("text/html", email.HTML)
+	}
+
+	return msg
+}
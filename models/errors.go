@@ -9,8 +9,25 @@ import (
 )
 
 var (
-	ErrNotFound   = errors.New("models: no resource could not be found")
-	ErrEmailTaken = errors.New("models: email address is already in use")
+	ErrNotFound    = errors.New("models: no resource could not be found")
+	ErrEmailTaken  = errors.New("models: email address is already in use")
+	ErrInvalidName = errors.New("models: name is empty")
+
+	ErrInvalidUsername  = errors.New("models: username is not valid")
+	ErrUsernameReserved = errors.New("models: username is reserved")
+	ErrUsernameTaken    = errors.New("models: username is already in use")
+
+	ErrInvalidRole   = errors.New("models: role is not valid")
+	ErrAlreadyMember = errors.New("models: user is already a member of the gallery")
+
+	ErrWrongGalleryPassword = errors.New("models: gallery password is wrong")
+	ErrTooManyAttempts      = errors.New("models: too many wrong attempts")
+	ErrTooManyComments      = errors.New("models: too many comments in a short time")
+	ErrCommentTooDeep       = errors.New("models: replies are nested too deeply")
+	ErrTooManySelections    = errors.New("models: too many selections in a short time")
+
+	ErrInvalidCredentials = errors.New("models: invalid email address or password")
+	ErrTokenExpired       = errors.New("models: token has expired")
 )
 
 type FileError struct {
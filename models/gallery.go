@@ -2,46 +2,130 @@ package models
 
 import (
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
+	"unicode"
+
+	"github.com/IrakliGiorgadze/go-web-app/rand"
 )
 
 type Image struct {
+	ID        int
 	GalleryID int
 	Path      string
-	Filename  string
+	// ThumbnailPath is where the scaled down copy made by ProcessImage is
+	// stored. The file doesn't exist until the image has been processed.
+	ThumbnailPath string
+	Filename      string
+	Name          string
+	Position      int
+	Caption       string
+	AltText       string
+	// Width and Height are zero until the image has been processed.
+	Width  int
+	Height int
+	// UploadedBy is the ID of the member who uploaded the image, or zero if
+	// they have since deleted their account.
+	UploadedBy   int
+	UploaderName string
+	// Views is only set by Images.
+	Views int
 }
 
 type Gallery struct {
-	ID     int
-	UserID int
-	Title  string
+	ID           int
+	UserID       int
+	Title        string
+	Description  string
+	Public       bool
+	Tags         []string
+	CoverImageID int
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	// Cover is the filename of the cover image, falling back to the first
+	// image in the gallery when no cover has been chosen.
+	Cover string
+	// Role is the role of the user the gallery was listed for by ByUserID.
+	Role Role
+	// PasswordHash is the bcrypt hash of the password visitors need to see
+	// the gallery, or empty if it has none. Only ByID sets it.
+	PasswordHash string
+	// Comments is only set by ByID.
+	Comments CommentMode
+	// Views is set by ByID and Popular, Likes only by Popular.
+	Views int
+	Likes int
 }
 
+// coverQuery selects the cover image of the gallery in the enclosing query,
+// or its first image when no cover has been set.
+const coverQuery = `
+	SELECT images.filename
+	FROM images
+	WHERE images.gallery_id = galleries.id
+	ORDER BY images.id = galleries.cover_image_id DESC NULLS LAST, images.position, images.id
+	LIMIT 1`
+
 type GalleryService struct {
 	DB        *sql.DB
 	ImagesDir string
+	// Jobs queues new images for processing. Images are left unprocessed
+	// when it is nil.
+	Jobs *JobService
 }
 
+// Create makes a gallery with userID as its owner.
 func (service *GalleryService) Create(title string, userID int) (*Gallery, error) {
 	gallery := Gallery{
 		UserID: userID,
 		Title:  title,
+		Role:   RoleOwner,
 	}
 
-	row := service.DB.QueryRow(
+	tx, err := service.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("create gallery: %w", err)
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow(
 		`
 		INSERT INTO galleries (title, user_id)
-		VALUES ($1, $2) RETURNING id;`,
+		VALUES ($1, $2) RETURNING id, created_at, updated_at;`,
 		gallery.Title,
 		gallery.UserID,
 	)
-	err := row.Scan(&gallery.ID)
+	err = row.Scan(&gallery.ID, &gallery.CreatedAt, &gallery.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("create gallery: %w", err)
+	}
+
+	_, err = tx.Exec(
+		`
+		INSERT INTO gallery_members (gallery_id, user_id, role)
+		VALUES ($1, $2, $3);`,
+		gallery.ID,
+		gallery.UserID,
+		RoleOwner,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create gallery: %w", err)
+	}
+
+	err = service.refreshSearch(tx, gallery.ID)
+	if err != nil {
+		return nil, fmt.Errorf("create gallery: %w", err)
+	}
+
+	err = tx.Commit()
 	if err != nil {
 		return nil, fmt.Errorf("create gallery: %w", err)
 	}
@@ -54,14 +138,21 @@ func (service *GalleryService) ByID(id int) (*Gallery, error) {
 		ID: id,
 	}
 
+	var coverImageID sql.NullInt64
+	var cover sql.NullString
 	row := service.DB.QueryRow(
 		`
-		SELECT title, user_id
+		SELECT galleries.title, galleries.user_id, galleries.description, galleries.public,
+			galleries.created_at, galleries.updated_at, galleries.cover_image_id, cover.filename,
+			galleries.password_hash, galleries.comments, galleries.views
 		FROM galleries
-		WHERE id = $1;`,
+			LEFT JOIN LATERAL (`+coverQuery+`) cover ON true
+		WHERE galleries.id = $1;`,
 		gallery.ID,
 	)
-	err := row.Scan(&gallery.Title, &gallery.UserID)
+	err := row.Scan(&gallery.Title, &gallery.UserID, &gallery.Description, &gallery.Public,
+		&gallery.CreatedAt, &gallery.UpdatedAt, &coverImageID, &cover,
+		&gallery.PasswordHash, &gallery.Comments, &gallery.Views)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNotFound
@@ -69,57 +160,123 @@ func (service *GalleryService) ByID(id int) (*Gallery, error) {
 
 		return nil, fmt.Errorf("query gallery by id: %w", err)
 	}
+	gallery.CoverImageID = int(coverImageID.Int64)
+	gallery.Cover = cover.String
+
+	gallery.Tags, err = service.tags(gallery.ID)
+	if err != nil {
+		return nil, fmt.Errorf("query gallery by id: %w", err)
+	}
 
 	return &gallery, nil
 }
 
-func (service *GalleryService) ByUserID(userID int) ([]Gallery, error) {
+// GallerySorts are the orders galleries can be listed in.
+var GallerySorts = Sorts{
+	"created":  {Column: "galleries.created_at", Type: "timestamptz"},
+	"-created": {Column: "galleries.created_at", Type: "timestamptz", Desc: true},
+	"updated":  {Column: "galleries.updated_at", Type: "timestamptz"},
+	"-updated": {Column: "galleries.updated_at", Type: "timestamptz", Desc: true},
+	"title":    {Column: "lower(galleries.title)", Type: "text"},
+	"-title":   {Column: "lower(galleries.title)", Type: "text", Desc: true},
+}
+
+const DefaultGallerySort = "-created"
+
+// ByUserID lists the galleries the user is a member of, including the ones
+// they own.
+func (service *GalleryService) ByUserID(userID int, sort string, req PageRequest) (*Page[Gallery], error) {
+	s, err := GallerySorts.Lookup(sort, DefaultGallerySort)
+	if err != nil {
+		return nil, fmt.Errorf("query galleries by user: %w", err)
+	}
+
+	k, err := newKeyset(s, req)
+	if err != nil {
+		return nil, fmt.Errorf("query galleries by user: %w", err)
+	}
+
+	where, args := k.where("galleries.id", 2)
 	rows, err := service.DB.Query(
 		`
-		SELECT id, title
+		SELECT galleries.id, galleries.user_id, galleries.title, galleries.created_at, galleries.updated_at,
+			galleries.cover_image_id, cover.filename, gallery_members.role, `+k.selectKey()+`
 		FROM galleries
-		WHERE user_id = $1;`,
-		userID,
+			JOIN gallery_members ON gallery_members.gallery_id = galleries.id
+			LEFT JOIN LATERAL (`+coverQuery+`) cover ON true
+		WHERE gallery_members.user_id = $1 AND `+where+`
+		`+k.orderBy("galleries.id")+`;`,
+		append([]any{userID}, args...)...,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("query galleries by user: %w", err)
 	}
+	defer rows.Close()
 
 	var galleries []Gallery
+	var keys []cursor
 
 	for rows.Next() {
-		gallery := Gallery{
-			UserID: userID,
-		}
-
-		err = rows.Scan(&gallery.ID, &gallery.Title)
+		var gallery Gallery
+		var coverImageID sql.NullInt64
+		var cover sql.NullString
+		var key string
+		err = rows.Scan(&gallery.ID, &gallery.UserID, &gallery.Title, &gallery.CreatedAt, &gallery.UpdatedAt,
+			&coverImageID, &cover, &gallery.Role, &key)
 		if err != nil {
 			return nil, fmt.Errorf("query galleries by user: %w", err)
 		}
+		gallery.CoverImageID = int(coverImageID.Int64)
+		gallery.Cover = cover.String
 
 		galleries = append(galleries, gallery)
+		keys = append(keys, cursor{Key: key, ID: gallery.ID})
 	}
 
 	if rows.Err() != nil {
-		return nil, fmt.Errorf("query galleries by user: %w", err)
+		return nil, fmt.Errorf("query galleries by user: %w", rows.Err())
 	}
 
-	return galleries, nil
+	return newPage(k, galleries, keys), nil
 }
 
 func (service *GalleryService) Update(gallery *Gallery) error {
-	_, err := service.DB.Exec(
+	tx, err := service.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("update gallery: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
 		`
 		UPDATE galleries
-		SET title = $2
+		SET title = $2, description = $3, public = $4, updated_at = now()
 		WHERE id = $1;`,
 		gallery.ID,
 		gallery.Title,
+		gallery.Description,
+		gallery.Public,
 	)
 	if err != nil {
 		return fmt.Errorf("update gallery: %w", err)
 	}
 
+	gallery.Tags = NormalizeTags(gallery.Tags)
+	err = service.setTags(tx, gallery.ID, gallery.Tags)
+	if err != nil {
+		return fmt.Errorf("update gallery: %w", err)
+	}
+
+	err = service.refreshSearch(tx, gallery.ID)
+	if err != nil {
+		return fmt.Errorf("update gallery: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("update gallery: %w", err)
+	}
+
 	return nil
 }
 
@@ -143,86 +300,332 @@ func (service *GalleryService) Delete(id int) error {
 }
 
 func (service *GalleryService) Images(galleryID int) ([]Image, error) {
-	globPattern := filepath.Join(service.galleryDir(galleryID), "*")
-	allFiles, err := filepath.Glob(globPattern)
+	rows, err := service.DB.Query(
+		`
+		SELECT images.id, images.filename, images.name, images.position, images.caption,
+			images.alt_text, images.width, images.height, COALESCE(images.uploaded_by, 0),
+			COALESCE(NULLIF(users.display_name, ''), users.username, users.email, ''), images.views
+		FROM images
+			LEFT JOIN users ON users.id = images.uploaded_by
+		WHERE images.gallery_id = $1
+		ORDER BY images.position, images.id;`,
+		galleryID,
+	)
 	if err != nil {
 		return nil, fmt.Errorf("retrieving gallery images: %w", err)
 	}
+	defer rows.Close()
 
 	var images []Image
-	for _, file := range allFiles {
-		if hasExtension(file, service.extensions()) {
-			images = append(images, Image{
-				GalleryID: galleryID,
-				Path:      file,
-				Filename:  filepath.Base(file),
-			})
+	for rows.Next() {
+		image := Image{
+			GalleryID: galleryID,
 		}
+
+		err = rows.Scan(&image.ID, &image.Filename, &image.Name, &image.Position, &image.Caption, &image.AltText, &image.Width, &image.Height,
+			&image.UploadedBy, &image.UploaderName, &image.Views)
+		if err != nil {
+			return nil, fmt.Errorf("retrieving gallery images: %w", err)
+		}
+
+		service.setImagePaths(&image)
+		images = append(images, image)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("retrieving gallery images: %w", rows.Err())
 	}
 
 	return images, nil
 }
 
+// Image looks an image up by its storage filename. Links created before
+// images had generated filenames used the uploaded name instead, so that
+// is accepted as a fallback. Names aren't unique, so the fallback is only
+// for showing images; anything that changes one uses ImageByFilename.
 func (service *GalleryService) Image(galleryID int, filename string) (Image, error) {
-	imagePath := filepath.Join(service.galleryDir(galleryID), filename)
-	_, err := os.Stat(imagePath)
+	return service.image(galleryID, filename, true)
+}
+
+// ImageByFilename looks an image up by its storage filename only.
+func (service *GalleryService) ImageByFilename(galleryID int, filename string) (Image, error) {
+	return service.image(galleryID, filename, false)
+}
+
+func (service *GalleryService) image(galleryID int, filename string, byName bool) (Image, error) {
+	image := Image{
+		GalleryID: galleryID,
+	}
+
+	row := service.DB.QueryRow(
+		`
+		SELECT id, filename, name, position, caption, alt_text, width, height, COALESCE(uploaded_by, 0)
+		FROM images
+		WHERE gallery_id = $1 AND (filename = $2 OR ($3 AND name = $2))
+		ORDER BY filename = $2 DESC, id DESC
+		LIMIT 1;`,
+		galleryID,
+		filename,
+		byName,
+	)
+	err := row.Scan(&image.ID, &image.Filename, &image.Name, &image.Position, &image.Caption, &image.AltText, &image.Width, &image.Height,
+		&image.UploadedBy)
 	if err != nil {
-		if errors.Is(err, fs.ErrNotExist) {
+		if errors.Is(err, sql.ErrNoRows) {
 			return Image{}, ErrNotFound
 		}
-		return Image{}, fmt.Errorf("queryng for image: %w", err)
+		return Image{}, fmt.Errorf("querying for image: %w", err)
 	}
 
-	return Image{
-		GalleryID: galleryID,
-		Path:      imagePath,
-		Filename:  filename,
-	}, nil
+	service.setImagePaths(&image)
+
+	return image, nil
 }
 
-func (service *GalleryService) CreateImage(galleryID int, filename string, contents io.ReadSeeker) error {
+// CreateImage stores contents under a generated filename, recording userID
+// as the uploader. The uploaded filename is only used to check the
+// extension and as the display name.
+func (service *GalleryService) CreateImage(galleryID, userID int, filename string, contents io.ReadSeeker) (*Image, error) {
 	err := checkContentType(contents, service.imageContentTypes())
 	if err != nil {
-		return fmt.Errorf("creating image (type) %v: %w", filename, err)
+		return nil, fmt.Errorf("creating image (type) %v: %w", filename, err)
 	}
 
 	err = checkExtension(filename, service.extensions())
 	if err != nil {
-		return fmt.Errorf("creating image (extension) %v: %w", filename, err)
+		return nil, fmt.Errorf("creating image (extension) %v: %w", filename, err)
 	}
 
 	galleryDir := service.galleryDir(galleryID)
 	err = os.MkdirAll(galleryDir, 0755)
 	if err != nil {
-		return fmt.Errorf("creating gallery-%d images directory: %w", galleryID, err)
+		return nil, fmt.Errorf("creating gallery-%d images directory: %w", galleryID, err)
 	}
 
-	imagePath := filepath.Join(galleryDir, filename)
-	dst, err := os.Create(imagePath)
+	key, err := rand.Bytes(16)
 	if err != nil {
-		return fmt.Errorf("creating image file: %w", err)
+		return nil, fmt.Errorf("creating image filename: %w", err)
+	}
+
+	image := Image{
+		GalleryID:  galleryID,
+		Filename:   hex.EncodeToString(key) + strings.ToLower(filepath.Ext(filename)),
+		Name:       displayName(filename),
+		UploadedBy: userID,
+	}
+	service.setImagePaths(&image)
+
+	dst, err := os.OpenFile(image.Path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("creating image file: %w", err)
 	}
 	defer dst.Close()
 
 	_, err = io.Copy(dst, contents)
 	if err != nil {
-		return fmt.Errorf("copying contents to image: %w", err)
+		os.Remove(image.Path)
+		return nil, fmt.Errorf("copying contents to image: %w", err)
+	}
+
+	row := service.DB.QueryRow(
+		`
+		INSERT INTO images (gallery_id, filename, name, position, uploaded_by)
+		SELECT $1, $2, $3, COALESCE(MAX(position), 0) + 1, NULLIF($4, 0)
+		FROM images WHERE gallery_id = $1
+		RETURNING id, position;`,
+		image.GalleryID,
+		image.Filename,
+		image.Name,
+		image.UploadedBy,
+	)
+	err = row.Scan(&image.ID, &image.Position)
+	if err != nil {
+		os.Remove(image.Path)
+		return nil, fmt.Errorf("creating image: %w", err)
+	}
+
+	if service.Jobs != nil {
+		// The image is usable without a thumbnail, so a failure to queue
+		// one isn't worth failing the upload for.
+		err = service.Jobs.Enqueue(JobProcessImage, processImagePayload{
+			GalleryID: image.GalleryID,
+			Filename:  image.Filename,
+		})
+		if err != nil {
+			log.Printf("queueing a thumbnail for image %d: %v", image.ID, err)
+		}
+	}
+
+	return &image, nil
+}
+
+// UpdateImage saves the display name, caption and alt text of an image.
+func (service *GalleryService) UpdateImage(image *Image) error {
+	image.Name = displayName(image.Name)
+	if image.Name == "" {
+		return fmt.Errorf("update image: %w", ErrInvalidName)
+	}
+
+	_, err := service.DB.Exec(
+		`
+		UPDATE images
+		SET name = $2, caption = $3, alt_text = $4
+		WHERE id = $1;`,
+		image.ID,
+		image.Name,
+		strings.TrimSpace(image.Caption),
+		strings.TrimSpace(image.AltText),
+	)
+	if err != nil {
+		return fmt.Errorf("update image: %w", err)
+	}
+
+	err = service.refreshSearch(service.DB, image.GalleryID)
+	if err != nil {
+		return fmt.Errorf("update image: %w", err)
+	}
+
+	return nil
+}
+
+// ReorderImages sets the position of each image to its index in filenames.
+// Images that are not listed keep their position after the listed ones.
+func (service *GalleryService) ReorderImages(galleryID int, filenames []string) error {
+	tx, err := service.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("reorder images: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`
+		UPDATE images
+		SET position = position + $2
+		WHERE gallery_id = $1;`,
+		galleryID,
+		len(filenames),
+	)
+	if err != nil {
+		return fmt.Errorf("reorder images: %w", err)
+	}
+
+	for i, filename := range filenames {
+		_, err = tx.Exec(
+			`
+			UPDATE images
+			SET position = $3
+			WHERE gallery_id = $1 AND filename = $2;`,
+			galleryID,
+			filename,
+			i,
+		)
+		if err != nil {
+			return fmt.Errorf("reorder images: %w", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("reorder images: %w", err)
+	}
+
+	return nil
+}
+
+// SetCover makes the image with the given filename the gallery cover. An
+// empty filename clears the cover.
+func (service *GalleryService) SetCover(galleryID int, filename string) error {
+	var imageID sql.NullInt64
+	if filename != "" {
+		image, err := service.ImageByFilename(galleryID, filename)
+		if err != nil {
+			return fmt.Errorf("set cover: %w", err)
+		}
+		imageID.Int64 = int64(image.ID)
+		imageID.Valid = true
+	}
+
+	_, err := service.DB.Exec(
+		`
+		UPDATE galleries
+		SET cover_image_id = $2
+		WHERE id = $1;`,
+		galleryID,
+		imageID,
+	)
+	if err != nil {
+		return fmt.Errorf("set cover: %w", err)
 	}
 
 	return nil
 }
 
 func (service *GalleryService) DeleteImage(galleryID int, filename string) error {
-	image, err := service.Image(galleryID, filename)
+	image, err := service.ImageByFilename(galleryID, filename)
+	if err != nil {
+		return fmt.Errorf("deleting image: %w", err)
+	}
+
+	_, err = service.DB.Exec(
+		`
+		DELETE FROM images
+		WHERE id = $1;`,
+		image.ID,
+	)
 	if err != nil {
 		return fmt.Errorf("deleting image: %w", err)
 	}
 
-	err = os.Remove(image.Path)
+	err = service.refreshSearch(service.DB, galleryID)
 	if err != nil {
 		return fmt.Errorf("deleting image: %w", err)
 	}
 
+	for _, path := range []string{image.Path, image.ThumbnailPath} {
+		err = os.Remove(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("deleting image: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ImportLegacyImages registers image files that were written to disk before
+// images were tracked in the database. Their filename on disk is kept as the
+// storage filename so that existing URLs keep working.
+func (service *GalleryService) ImportLegacyImages() error {
+	globPattern := filepath.Join(service.imagesDir(), "gallery-*", "*")
+	allFiles, err := filepath.Glob(globPattern)
+	if err != nil {
+		return fmt.Errorf("import legacy images: %w", err)
+	}
+
+	for _, file := range allFiles {
+		if !hasExtension(file, service.extensions()) {
+			continue
+		}
+
+		var galleryID int
+		_, err = fmt.Sscanf(filepath.Base(filepath.Dir(file)), "gallery-%d", &galleryID)
+		if err != nil {
+			continue
+		}
+
+		filename := filepath.Base(file)
+		_, err = service.DB.Exec(
+			`
+			INSERT INTO images (gallery_id, filename, name)
+			SELECT id, $2, $2 FROM galleries WHERE id = $1
+			ON CONFLICT (gallery_id, filename) DO NOTHING;`,
+			galleryID,
+			filename,
+		)
+		if err != nil {
+			return fmt.Errorf("import legacy image %v: %w", file, err)
+		}
+	}
+
 	return nil
 }
 
@@ -234,13 +637,22 @@ func (service *GalleryService) imageContentTypes() []string {
 	return []string{"image/png", "image/jpeg", "image/gif"}
 }
 
-func (service *GalleryService) galleryDir(id int) string {
-	imagesDir := service.ImagesDir
-	if imagesDir == "" {
-		imagesDir = "images"
+func (service *GalleryService) imagesDir() string {
+	if service.ImagesDir == "" {
+		return "images"
 	}
 
-	return filepath.Join(imagesDir, fmt.Sprintf("gallery-%d", id))
+	return service.ImagesDir
+}
+
+func (service *GalleryService) galleryDir(id int) string {
+	return filepath.Join(service.imagesDir(), fmt.Sprintf("gallery-%d", id))
+}
+
+func (service *GalleryService) setImagePaths(image *Image) {
+	galleryDir := service.galleryDir(image.GalleryID)
+	image.Path = filepath.Join(galleryDir, image.Filename)
+	image.ThumbnailPath = filepath.Join(galleryDir, "thumbs", image.Filename+".jpg")
 }
 
 func hasExtension(file string, extensions []string) bool {
@@ -254,3 +666,25 @@ func hasExtension(file string, extensions []string) bool {
 
 	return false
 }
+
+// displayName strips any client path and control characters from an
+// uploaded filename so that it can be shown to users.
+func displayName(filename string) string {
+	if i := strings.LastIndexAny(filename, `/\`); i >= 0 {
+		filename = filename[i+1:]
+	}
+
+	name := strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, filename))
+
+	runes := []rune(name)
+	if len(runes) > 255 {
+		name = string(runes[:255])
+	}
+
+	return name
+}
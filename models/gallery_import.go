@@ -0,0 +1,198 @@
+package models
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	DefaultMaxZipEntries    = 1000
+	DefaultMaxZipRatio      = 100
+	DefaultMaxZipEntrySize  = 64 << 20 // 64mb
+	DefaultMaxZipTotalSize  = 4 << 30  // 4gb
+	zipMaxRatioCheckMinSize = 1 << 20
+)
+
+var (
+	ErrTooManyEntries  = errors.New("models: archive has too many entries")
+	ErrArchiveTooLarge = errors.New("models: archive expands beyond the size limit")
+	errDamagedEntry    = errors.New("models: archive entry can't be extracted")
+)
+
+// ZipLimits guard ImportZip against archives that are crafted to exhaust
+// disk space or memory.
+type ZipLimits struct {
+	MaxEntries   int
+	MaxRatio     int
+	MaxEntrySize int64
+	MaxTotalSize int64
+}
+
+func (l ZipLimits) withDefaults() ZipLimits {
+	if l.MaxEntries <= 0 {
+		l.MaxEntries = DefaultMaxZipEntries
+	}
+	if l.MaxRatio <= 0 {
+		l.MaxRatio = DefaultMaxZipRatio
+	}
+	if l.MaxEntrySize <= 0 {
+		l.MaxEntrySize = DefaultMaxZipEntrySize
+	}
+	if l.MaxTotalSize <= 0 {
+		l.MaxTotalSize = DefaultMaxZipTotalSize
+	}
+
+	return l
+}
+
+type ImportedEntry struct {
+	Name string
+	// Skipped explains why the entry wasn't imported. It is empty for
+	// entries that were stored as images.
+	Skipped string
+}
+
+// ImportZip stores every image in a ZIP archive in the gallery. Entries are
+// validated the same way as uploaded files; those that fail are skipped and
+// reported rather than aborting the import. An error is only returned when
+// the archive as a whole can't be read or exceeds the limits. userID is
+// recorded as the uploader of every image.
+func (service *GalleryService) ImportZip(galleryID, userID int, r io.ReaderAt, size int64, limits ZipLimits) ([]ImportedEntry, error) {
+	limits = limits.withDefaults()
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("import zip: %w", err)
+	}
+
+	if len(zr.File) > limits.MaxEntries {
+		return nil, fmt.Errorf("import zip: %w", ErrTooManyEntries)
+	}
+
+	var total int64
+	var entries []ImportedEntry
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || isArchiveJunk(f.Name) {
+			continue
+		}
+
+		entry := ImportedEntry{
+			Name: f.Name,
+		}
+
+		switch {
+		case !safeArchivePath(f.Name):
+			entry.Skipped = "The entry has an unsafe path."
+		case f.UncompressedSize64 == 0:
+			entry.Skipped = "The file is empty."
+		case f.UncompressedSize64 > uint64(limits.MaxEntrySize):
+			entry.Skipped = "The file is too large."
+		case f.UncompressedSize64 > zipMaxRatioCheckMinSize &&
+			f.UncompressedSize64 > f.CompressedSize64*uint64(limits.MaxRatio):
+			entry.Skipped = "The file is compressed suspiciously well."
+		}
+		if entry.Skipped != "" {
+			entries = append(entries, entry)
+			continue
+		}
+
+		n, err := service.importZipEntry(galleryID, userID, f, limits)
+		total += n
+		if total > limits.MaxTotalSize {
+			return entries, fmt.Errorf("import zip: %w", ErrArchiveTooLarge)
+		}
+		if err != nil {
+			var fileErr FileError
+			switch {
+			case errors.As(err, &fileErr):
+				entry.Skipped = "Only png, gif, jpeg and jpg files can be imported."
+			case errors.Is(err, ErrArchiveTooLarge):
+				entry.Skipped = "The file is too large."
+			case errors.Is(err, errDamagedEntry):
+				entry.Skipped = "The file is damaged and couldn't be extracted."
+			default:
+				return entries, fmt.Errorf("import zip: %w", err)
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// importZipEntry extracts one entry to a temporary file and stores it as an
+// image. The declared sizes in the archive can't be trusted, so the number of
+// bytes actually extracted is limited and returned.
+func (service *GalleryService) importZipEntry(galleryID, userID int, f *zip.File, limits ZipLimits) (int64, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return 0, fmt.Errorf("open %v: %w", f.Name, err)
+	}
+	defer rc.Close()
+
+	tmp, err := os.CreateTemp("", "import-*")
+	if err != nil {
+		return 0, fmt.Errorf("extract %v: %w", f.Name, err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	maxSize := limits.MaxEntrySize
+	if ratioSize := int64(f.CompressedSize64) * int64(limits.MaxRatio); ratioSize > zipMaxRatioCheckMinSize && ratioSize < maxSize {
+		maxSize = ratioSize
+	}
+
+	n, err := io.Copy(tmp, io.LimitReader(rc, maxSize+1))
+	if err != nil {
+		log.Printf("extracting %s: %v", f.Name, err)
+		return n, errDamagedEntry
+	}
+	if n > maxSize {
+		return n, ErrArchiveTooLarge
+	}
+	if n == 0 {
+		return n, errDamagedEntry
+	}
+
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return n, fmt.Errorf("extract %v: %w", f.Name, err)
+	}
+
+	_, err = service.CreateImage(galleryID, userID, path.Base(f.Name), tmp)
+	if err != nil {
+		return n, err
+	}
+
+	return n, nil
+}
+
+// safeArchivePath rejects absolute paths and paths that climb out of the
+// archive root. Entries are never written to their own paths, but names like
+// these only appear in archives built to attack extractors.
+func safeArchivePath(name string) bool {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || strings.Contains(name, ":") {
+		return false
+	}
+
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isArchiveJunk(name string) bool {
+	base := path.Base(strings.ReplaceAll(name, "\\", "/"))
+	return strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(base, ".") || base == "Thumbs.db"
+}
@@ -0,0 +1,273 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/IrakliGiorgadze/go-web-app/rand"
+)
+
+const (
+	DefaultInvitationDuration = 7 * 24 * time.Hour
+)
+
+type GalleryInvitation struct {
+	ID        int
+	GalleryID int
+	Email     string
+	Role      Role
+	InvitedBy int
+	Token     string
+	TokenHash string
+	ExpiresAt time.Time
+	// GalleryTitle and InviterName are only set by ByToken, for showing
+	// the invitation to the person invited.
+	GalleryTitle string
+	InviterName  string
+}
+
+// GalleryInvitationService issues the links that make someone a member of
+// a gallery. Like a password reset link, the link itself is what grants
+// access, so whoever follows it can accept the invitation.
+type GalleryInvitationService struct {
+	DB            *sql.DB
+	BytesPerToken int
+	Duration      time.Duration
+}
+
+// Create invites email to the gallery with the role. Inviting an address
+// again replaces the earlier invitation, so only the latest link works.
+func (service *GalleryInvitationService) Create(galleryID, invitedBy int, email string, role Role) (*GalleryInvitation, error) {
+	if !role.Invitable() {
+		return nil, fmt.Errorf("create invitation: %w", ErrInvalidRole)
+	}
+
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	var isMember bool
+	err := service.DB.QueryRow(
+		`
+		SELECT EXISTS (
+			SELECT 1
+			FROM gallery_members
+				JOIN users ON users.id = gallery_members.user_id
+			WHERE gallery_members.gallery_id = $1 AND users.email = $2);`,
+		galleryID,
+		email,
+	).Scan(&isMember)
+	if err != nil {
+		return nil, fmt.Errorf("create invitation: %w", err)
+	}
+	if isMember {
+		return nil, fmt.Errorf("create invitation: %w", ErrAlreadyMember)
+	}
+
+	bytesPerToken := service.BytesPerToken
+	if bytesPerToken < MinBytesPerToken {
+		bytesPerToken = MinBytesPerToken
+	}
+
+	token, err := rand.String(bytesPerToken)
+	if err != nil {
+		return nil, fmt.Errorf("create invitation: %w", err)
+	}
+
+	duration := service.Duration
+	if duration == 0 {
+		duration = DefaultInvitationDuration
+	}
+
+	invitation := GalleryInvitation{
+		GalleryID: galleryID,
+		Email:     email,
+		Role:      role,
+		InvitedBy: invitedBy,
+		Token:     token,
+		TokenHash: service.hash(token),
+		ExpiresAt: time.Now().Add(duration),
+	}
+
+	row := service.DB.QueryRow(
+		`
+		INSERT INTO gallery_invitations (gallery_id, email, role, invited_by, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (gallery_id, email) DO
+		UPDATE
+		SET role = $3, invited_by = $4, token_hash = $5, expires_at = $6, created_at = now()
+		RETURNING id;`,
+		invitation.GalleryID,
+		invitation.Email,
+		invitation.Role,
+		invitation.InvitedBy,
+		invitation.TokenHash,
+		invitation.ExpiresAt,
+	)
+	err = row.Scan(&invitation.ID)
+	if err != nil {
+		return nil, fmt.Errorf("create invitation: %w", err)
+	}
+
+	return &invitation, nil
+}
+
+// ByGallery lists the invitations to a gallery that haven't been accepted
+// or expired yet.
+func (service *GalleryInvitationService) ByGallery(galleryID int) ([]GalleryInvitation, error) {
+	rows, err := service.DB.Query(
+		`
+		SELECT id, email, role, COALESCE(invited_by, 0), expires_at
+		FROM gallery_invitations
+		WHERE gallery_id = $1 AND expires_at > now()
+		ORDER BY created_at, id;`,
+		galleryID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("gallery invitations: %w", err)
+	}
+	defer rows.Close()
+
+	var invitations []GalleryInvitation
+	for rows.Next() {
+		invitation := GalleryInvitation{
+			GalleryID: galleryID,
+		}
+		err = rows.Scan(&invitation.ID, &invitation.Email, &invitation.Role, &invitation.InvitedBy, &invitation.ExpiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("gallery invitations: %w", err)
+		}
+		invitations = append(invitations, invitation)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("gallery invitations: %w", rows.Err())
+	}
+
+	return invitations, nil
+}
+
+// ByToken looks up an invitation for showing to the person invited. It
+// returns ErrNotFound or ErrTokenExpired if the link no longer works.
+func (service *GalleryInvitationService) ByToken(token string) (*GalleryInvitation, error) {
+	invitation, err := service.byToken(service.DB, token)
+	if err != nil {
+		return nil, fmt.Errorf("invitation by token: %w", err)
+	}
+
+	return invitation, nil
+}
+
+// Accept makes the user a member of the gallery with the invited role and
+// uses up the invitation. Existing members are only ever promoted by an
+// invitation, never demoted.
+func (service *GalleryInvitationService) Accept(token string, userID int) (*GalleryInvitation, error) {
+	tx, err := service.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("accept invitation: %w", err)
+	}
+	defer tx.Rollback()
+
+	invitation, err := service.byToken(tx, token)
+	if err != nil {
+		return nil, fmt.Errorf("accept invitation: %w", err)
+	}
+
+	_, err = tx.Exec(
+		`
+		INSERT INTO gallery_members (gallery_id, user_id, role)
+		VALUES ($1, $2, $3) ON CONFLICT (gallery_id, user_id) DO
+		UPDATE
+		SET role = excluded.role
+		WHERE gallery_members.role = 'viewer' AND excluded.role = 'editor';`,
+		invitation.GalleryID,
+		userID,
+		invitation.Role,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("accept invitation: %w", err)
+	}
+
+	_, err = tx.Exec(
+		`
+		DELETE FROM gallery_invitations
+		WHERE id = $1;`,
+		invitation.ID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("accept invitation: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("accept invitation: %w", err)
+	}
+
+	return invitation, nil
+}
+
+// Revoke deletes an invitation so that its link stops working.
+func (service *GalleryInvitationService) Revoke(galleryID, id int) error {
+	result, err := service.DB.Exec(
+		`
+		DELETE FROM gallery_invitations
+		WHERE gallery_id = $1 AND id = $2;`,
+		galleryID,
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("revoke invitation: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("revoke invitation: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("revoke invitation: %w", ErrNotFound)
+	}
+
+	return nil
+}
+
+func (service *GalleryInvitationService) byToken(db dbtx, token string) (*GalleryInvitation, error) {
+	invitation := GalleryInvitation{
+		Token: token,
+	}
+
+	row := db.QueryRow(
+		`
+		SELECT gallery_invitations.id, gallery_invitations.gallery_id, gallery_invitations.email,
+			gallery_invitations.role, COALESCE(gallery_invitations.invited_by, 0),
+			gallery_invitations.expires_at, COALESCE(galleries.title, ''),
+			COALESCE(NULLIF(users.display_name, ''), users.username, users.email, '')
+		FROM gallery_invitations
+			JOIN galleries ON galleries.id = gallery_invitations.gallery_id
+			LEFT JOIN users ON users.id = gallery_invitations.invited_by
+		WHERE gallery_invitations.token_hash = $1;`,
+		service.hash(token),
+	)
+	err := row.Scan(&invitation.ID, &invitation.GalleryID, &invitation.Email,
+		&invitation.Role, &invitation.InvitedBy,
+		&invitation.ExpiresAt, &invitation.GalleryTitle,
+		&invitation.InviterName)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	if time.Now().After(invitation.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+
+	return &invitation, nil
+}
+
+func (service *GalleryInvitationService) hash(token string) string {
+	tokenHash := sha256.Sum256([]byte(token))
+	return base64.URLEncoding.EncodeToString(tokenHash[:])
+}
@@ -0,0 +1,179 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Role is what a member can do with a gallery. Each role can do everything
+// the ones before it can:
+//
+//   - viewers see the gallery listed with their own galleries;
+//   - editors change its details and add, edit and remove images;
+//   - the owner deletes it and decides who else is a member.
+type Role string
+
+const (
+	RoleViewer Role = "viewer"
+	RoleEditor Role = "editor"
+	RoleOwner  Role = "owner"
+)
+
+var roleRanks = map[Role]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleOwner:  3,
+}
+
+// Can reports whether a member with the role may do what needs at least
+// min. The empty role, for people who aren't members, can do nothing.
+func (r Role) Can(min Role) bool {
+	return roleRanks[r] > 0 && roleRanks[r] >= roleRanks[min]
+}
+
+// Invitable reports whether members can be invited with the role. There
+// is only ever one owner.
+func (r Role) Invitable() bool {
+	return r == RoleEditor || r == RoleViewer
+}
+
+type Member struct {
+	GalleryID   int
+	UserID      int
+	Role        Role
+	Email       string
+	Username    string
+	DisplayName string
+	// Locale is the language the member chose for the site, for emails
+	// about the gallery.
+	Locale    string
+	CreatedAt time.Time
+}
+
+// Name is how the member is shown to the other members of a gallery.
+func (m Member) Name() string {
+	if m.DisplayName != "" {
+		return m.DisplayName
+	}
+	if m.Username != "" {
+		return m.Username
+	}
+
+	return m.Email
+}
+
+// Role returns the user's role in the gallery, or the empty Role if they
+// aren't a member.
+func (service *GalleryService) Role(galleryID, userID int) (Role, error) {
+	var role Role
+	err := service.DB.QueryRow(
+		`
+		SELECT role
+		FROM gallery_members
+		WHERE gallery_id = $1 AND user_id = $2;`,
+		galleryID,
+		userID,
+	).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", fmt.Errorf("gallery role: %w", err)
+	}
+
+	return role, nil
+}
+
+// Members lists the members of a gallery, owner first.
+func (service *GalleryService) Members(galleryID int) ([]Member, error) {
+	rows, err := service.DB.Query(
+		`
+		SELECT gallery_members.user_id, gallery_members.role, gallery_members.created_at,
+			users.email, COALESCE(users.username, ''), users.display_name, users.locale
+		FROM gallery_members
+			JOIN users ON users.id = gallery_members.user_id
+		WHERE gallery_members.gallery_id = $1
+		ORDER BY gallery_members.role = 'owner' DESC, gallery_members.created_at, users.id;`,
+		galleryID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("gallery members: %w", err)
+	}
+	defer rows.Close()
+
+	var members []Member
+	for rows.Next() {
+		member := Member{
+			GalleryID: galleryID,
+		}
+		err = rows.Scan(&member.UserID, &member.Role, &member.CreatedAt,
+			&member.Email, &member.Username, &member.DisplayName, &member.Locale)
+		if err != nil {
+			return nil, fmt.Errorf("gallery members: %w", err)
+		}
+		members = append(members, member)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("gallery members: %w", rows.Err())
+	}
+
+	return members, nil
+}
+
+// SetMemberRole changes the role of a member other than the owner.
+func (service *GalleryService) SetMemberRole(galleryID, userID int, role Role) error {
+	if !role.Invitable() {
+		return fmt.Errorf("set member role: %w", ErrInvalidRole)
+	}
+
+	result, err := service.DB.Exec(
+		`
+		UPDATE gallery_members
+		SET role = $3
+		WHERE gallery_id = $1 AND user_id = $2 AND role <> 'owner';`,
+		galleryID,
+		userID,
+		role,
+	)
+	if err != nil {
+		return fmt.Errorf("set member role: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("set member role: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("set member role: %w", ErrNotFound)
+	}
+
+	return nil
+}
+
+// RemoveMember takes away a member's access. The owner can't be removed;
+// they delete the gallery instead.
+func (service *GalleryService) RemoveMember(galleryID, userID int) error {
+	result, err := service.DB.Exec(
+		`
+		DELETE FROM gallery_members
+		WHERE gallery_id = $1 AND user_id = $2 AND role <> 'owner';`,
+		galleryID,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("remove member: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("remove member: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("remove member: %w", ErrNotFound)
+	}
+
+	return nil
+}
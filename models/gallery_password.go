@@ -0,0 +1,124 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// MaxGalleryPasswordAttempts is how many wrong passwords a client can
+	// try for a gallery within GalleryPasswordWindow before CheckPassword
+	// stops checking them.
+	MaxGalleryPasswordAttempts = 5
+	GalleryPasswordWindow      = 15 * time.Minute
+)
+
+// SetPassword sets the password visitors need to see the gallery. The empty
+// password removes it.
+func (service *GalleryService) SetPassword(galleryID int, password string) error {
+	var passwordHash string
+	if password != "" {
+		hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return fmt.Errorf("set gallery password: %w", err)
+		}
+		passwordHash = string(hashedBytes)
+	}
+
+	result, err := service.DB.Exec(
+		`
+		UPDATE galleries
+		SET password_hash = $2
+		WHERE id = $1;`,
+		galleryID,
+		passwordHash,
+	)
+	if err != nil {
+		return fmt.Errorf("set gallery password: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("set gallery password: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("set gallery password: %w", ErrNotFound)
+	}
+
+	return nil
+}
+
+// CheckPassword returns ErrWrongGalleryPassword unless password is the
+// gallery's. Wrong passwords are counted per client, such as an IP address,
+// and once a client has made MaxGalleryPasswordAttempts of them it gets
+// ErrTooManyAttempts until GalleryPasswordWindow has passed.
+func (service *GalleryService) CheckPassword(gallery *Gallery, client, password string) error {
+	var failures int
+	err := service.DB.QueryRow(
+		`
+		SELECT count(*)
+		FROM gallery_password_failures
+		WHERE gallery_id = $1 AND client = $2 AND created_at > $3;`,
+		gallery.ID,
+		client,
+		time.Now().Add(-GalleryPasswordWindow),
+	).Scan(&failures)
+	if err != nil {
+		return fmt.Errorf("check gallery password: %w", err)
+	}
+	if failures >= MaxGalleryPasswordAttempts {
+		return fmt.Errorf("check gallery password: %w", ErrTooManyAttempts)
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(gallery.PasswordHash), []byte(password))
+	if err != nil {
+		if !errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return fmt.Errorf("check gallery password: %w", err)
+		}
+
+		err = service.recordPasswordFailure(gallery.ID, client)
+		if err != nil {
+			return fmt.Errorf("check gallery password: %w", err)
+		}
+		return fmt.Errorf("check gallery password: %w", ErrWrongGalleryPassword)
+	}
+
+	_, err = service.DB.Exec(
+		`
+		DELETE FROM gallery_password_failures
+		WHERE gallery_id = $1 AND client = $2;`,
+		gallery.ID,
+		client,
+	)
+	if err != nil {
+		return fmt.Errorf("check gallery password: %w", err)
+	}
+
+	return nil
+}
+
+// recordPasswordFailure counts a wrong password, and clears out failures
+// too old to count any more so that the table doesn't grow forever.
+func (service *GalleryService) recordPasswordFailure(galleryID int, client string) error {
+	_, err := service.DB.Exec(
+		`
+		DELETE FROM gallery_password_failures
+		WHERE created_at <= $1;`,
+		time.Now().Add(-GalleryPasswordWindow),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = service.DB.Exec(
+		`
+		INSERT INTO gallery_password_failures (gallery_id, client)
+		VALUES ($1, $2);`,
+		galleryID,
+		client,
+	)
+	return err
+}
@@ -0,0 +1,323 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	MaxTags        = 20
+	MaxTagLength   = 32
+	DefaultPerPage = 20
+)
+
+// dbtx is implemented by both *sql.DB and *sql.Tx.
+type dbtx interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+type GallerySearch struct {
+	Query string
+	Tag   string
+	// UserID limits the search to one user's galleries when set.
+	UserID  int
+	Page    int
+	PerPage int
+}
+
+type GallerySearchResult struct {
+	Galleries []Gallery
+	Page      int
+	HasNext   bool
+}
+
+// Search finds public galleries matching a full-text query over the title,
+// description, tags and image captions, optionally limited to a tag. With
+// no query the most recently created galleries are returned first.
+// Galleries with a password are left out, since their covers can't be shown.
+func (service *GalleryService) Search(search GallerySearch) (*GallerySearchResult, error) {
+	perPage := search.PerPage
+	if perPage <= 0 {
+		perPage = DefaultPerPage
+	}
+
+	page := search.Page
+	if page < 1 {
+		page = 1
+	}
+
+	tag := normalizeTag(search.Tag)
+	rows, err := service.DB.Query(
+		`
+		SELECT galleries.id, galleries.user_id, galleries.title, galleries.description,
+			galleries.cover_image_id, cover.filename,
+			(SELECT coalesce(string_agg(tag, ',' ORDER BY tag), '')
+				FROM gallery_tags WHERE gallery_tags.gallery_id = galleries.id)
+		FROM galleries
+			LEFT JOIN LATERAL (`+coverQuery+`) cover ON true
+		WHERE galleries.public AND galleries.password_hash = ''
+			AND ($1 = '' OR galleries.search @@ websearch_to_tsquery('english', $1))
+			AND ($2 = '' OR EXISTS (
+				SELECT 1 FROM gallery_tags
+				WHERE gallery_tags.gallery_id = galleries.id AND gallery_tags.tag = $2))
+			AND ($5 = 0 OR galleries.user_id = $5)
+		ORDER BY ts_rank(galleries.search, websearch_to_tsquery('english', $1)) DESC, galleries.id DESC
+		LIMIT $3 OFFSET $4;`,
+		strings.TrimSpace(search.Query),
+		tag,
+		perPage+1,
+		(page-1)*perPage,
+		search.UserID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("search galleries: %w", err)
+	}
+	defer rows.Close()
+
+	result := GallerySearchResult{
+		Page: page,
+	}
+	for rows.Next() {
+		var gallery Gallery
+		var coverImageID sql.NullInt64
+		var cover sql.NullString
+		var tags string
+		err = rows.Scan(&gallery.ID, &gallery.UserID, &gallery.Title, &gallery.Description, &coverImageID, &cover, &tags)
+		if err != nil {
+			return nil, fmt.Errorf("search galleries: %w", err)
+		}
+		gallery.Public = true
+		gallery.CoverImageID = int(coverImageID.Int64)
+		gallery.Cover = cover.String
+		if tags != "" {
+			gallery.Tags = strings.Split(tags, ",")
+		}
+
+		result.Galleries = append(result.Galleries, gallery)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("search galleries: %w", rows.Err())
+	}
+
+	if len(result.Galleries) > perPage {
+		result.Galleries = result.Galleries[:perPage]
+		result.HasNext = true
+	}
+
+	return &result, nil
+}
+
+const (
+	// PopularWindow is how far back Popular looks for views and likes.
+	PopularWindow = 7 * 24 * time.Hour
+	// LikeWeight is how many views a like counts for in Popular.
+	LikeWeight = 5
+)
+
+// popularSort orders Popular by the score its query computes.
+var popularSort = Sort{Column: "popularity.score", Type: "bigint", Desc: true}
+
+// Popular lists public galleries by how many views and likes they got in
+// the last PopularWindow, counting likes of their images as well as of the
+// gallery. Galleries nobody looked at in that time are left out.
+func (service *GalleryService) Popular(req PageRequest) (*Page[Gallery], error) {
+	k, err := newKeyset(popularSort, req)
+	if err != nil {
+		return nil, fmt.Errorf("popular galleries: %w", err)
+	}
+
+	since := time.Now().Add(-PopularWindow)
+	where, args := k.where("galleries.id", 3)
+	rows, err := service.DB.Query(
+		`
+		SELECT galleries.id, galleries.user_id, galleries.title, galleries.description,
+			galleries.cover_image_id, cover.filename, galleries.views,
+			(SELECT count(*) FROM gallery_likes WHERE gallery_likes.gallery_id = galleries.id),
+			`+k.selectKey()+`
+		FROM galleries
+			LEFT JOIN LATERAL (`+coverQuery+`) cover ON true
+			JOIN LATERAL (
+				SELECT (
+					(SELECT coalesce(sum(views), 0)
+						FROM gallery_daily_views
+						WHERE gallery_daily_views.gallery_id = galleries.id
+							AND gallery_daily_views.day >= $1::date) +
+					$2 * (SELECT count(*)
+						FROM gallery_likes
+						WHERE gallery_likes.gallery_id = galleries.id
+							AND gallery_likes.created_at >= $1) +
+					$2 * (SELECT count(*)
+						FROM image_likes
+							JOIN images ON images.id = image_likes.image_id
+						WHERE images.gallery_id = galleries.id
+							AND image_likes.created_at >= $1)
+				)::bigint AS score
+			) popularity ON true
+		WHERE galleries.public AND galleries.password_hash = '' AND popularity.score > 0 AND `+where+`
+		`+k.orderBy("galleries.id")+`;`,
+		append([]any{since, LikeWeight}, args...)...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("popular galleries: %w", err)
+	}
+	defer rows.Close()
+
+	var galleries []Gallery
+	var keys []cursor
+
+	for rows.Next() {
+		var gallery Gallery
+		var coverImageID sql.NullInt64
+		var cover sql.NullString
+		var key string
+		err = rows.Scan(&gallery.ID, &gallery.UserID, &gallery.Title, &gallery.Description, &coverImageID, &cover,
+			&gallery.Views, &gallery.Likes, &key)
+		if err != nil {
+			return nil, fmt.Errorf("popular galleries: %w", err)
+		}
+		gallery.Public = true
+		gallery.CoverImageID = int(coverImageID.Int64)
+		gallery.Cover = cover.String
+
+		galleries = append(galleries, gallery)
+		keys = append(keys, cursor{Key: key, ID: gallery.ID})
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("popular galleries: %w", rows.Err())
+	}
+
+	return newPage(k, galleries, keys), nil
+}
+
+// NormalizeTags lowercases, trims and de-duplicates tags, dropping empty
+// ones and anything past MaxTags.
+func NormalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	var normalized []string
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+
+		seen[tag] = true
+		normalized = append(normalized, tag)
+		if len(normalized) == MaxTags {
+			break
+		}
+	}
+
+	return normalized
+}
+
+// ParseTags splits a comma separated list of tags.
+func ParseTags(s string) []string {
+	return NormalizeTags(strings.Split(s, ","))
+}
+
+func normalizeTag(tag string) string {
+	tag = strings.TrimLeft(strings.TrimSpace(tag), "#")
+	tag = strings.ToLower(strings.Join(strings.FieldsFunc(tag, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r) || r == ','
+	}), "-"))
+
+	runes := []rune(tag)
+	if len(runes) > MaxTagLength {
+		tag = string(runes[:MaxTagLength])
+	}
+
+	return tag
+}
+
+func (service *GalleryService) tags(galleryID int) ([]string, error) {
+	rows, err := service.DB.Query(
+		`
+		SELECT tag FROM gallery_tags
+		WHERE gallery_id = $1
+		ORDER BY tag;`,
+		galleryID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query tags: %w", err)
+	}
+	defer rows.Close()
+
+	var tags []string
+	for rows.Next() {
+		var tag string
+		err = rows.Scan(&tag)
+		if err != nil {
+			return nil, fmt.Errorf("query tags: %w", err)
+		}
+		tags = append(tags, tag)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("query tags: %w", rows.Err())
+	}
+
+	return tags, nil
+}
+
+func (service *GalleryService) setTags(db dbtx, galleryID int, tags []string) error {
+	_, err := db.Exec(
+		`
+		DELETE FROM gallery_tags
+		WHERE gallery_id = $1;`,
+		galleryID,
+	)
+	if err != nil {
+		return fmt.Errorf("set tags: %w", err)
+	}
+
+	for _, tag := range tags {
+		_, err = db.Exec(
+			`
+			INSERT INTO gallery_tags (gallery_id, tag)
+			VALUES ($1, $2);`,
+			galleryID,
+			tag,
+		)
+		if err != nil {
+			return fmt.Errorf("set tags: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// refreshSearch rebuilds the full-text search document of a gallery. It
+// needs to run whenever the title, description, tags or captions change.
+func (service *GalleryService) refreshSearch(db dbtx, galleryID int) error {
+	_, err := db.Exec(
+		`
+		UPDATE galleries
+		SET search =
+			setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+			setweight(to_tsvector('english', (
+				SELECT coalesce(string_agg(tag, ' '), '')
+				FROM gallery_tags
+				WHERE gallery_tags.gallery_id = galleries.id
+			)), 'A') ||
+			setweight(to_tsvector('english', description), 'B') ||
+			setweight(to_tsvector('english', (
+				SELECT coalesce(string_agg(caption, ' '), '')
+				FROM images
+				WHERE images.gallery_id = galleries.id
+			)), 'C')
+		WHERE id = $1;`,
+		galleryID,
+	)
+	if err != nil {
+		return fmt.Errorf("refresh search: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+const (
+	ThumbnailSize = 640
+	// maxDecodePixels keeps a single huge image from exhausting the
+	// worker's memory. Larger images are measured but not thumbnailed.
+	maxDecodePixels = 50_000_000
+)
+
+type processImagePayload struct {
+	GalleryID int
+	Filename  string
+}
+
+// ProcessImageJob handles JobProcessImage jobs.
+func (service *GalleryService) ProcessImageJob(job *Job) error {
+	var payload processImagePayload
+	err := job.Decode(&payload)
+	if err != nil {
+		return err
+	}
+
+	err = service.ProcessImage(payload.GalleryID, payload.Filename)
+	if errors.Is(err, ErrNotFound) {
+		// The image was deleted before the job ran.
+		return nil
+	}
+
+	return err
+}
+
+// ProcessImage records the dimensions of an image and writes a JPEG
+// thumbnail that fits within ThumbnailSize pixels.
+func (service *GalleryService) ProcessImage(galleryID int, filename string) error {
+	img, err := service.ImageByFilename(galleryID, filename)
+	if err != nil {
+		return fmt.Errorf("process image: %w", err)
+	}
+
+	f, err := os.Open(img.Path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("process image: %w", ErrNotFound)
+		}
+		return fmt.Errorf("process image: %w", err)
+	}
+	defer f.Close()
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return fmt.Errorf("process image %v: %w: %v", img.Filename, ErrJobFailed, err)
+	}
+
+	_, err = service.DB.Exec(
+		`
+		UPDATE images
+		SET width = $2, height = $3
+		WHERE id = $1;`,
+		img.ID,
+		cfg.Width,
+		cfg.Height,
+	)
+	if err != nil {
+		return fmt.Errorf("process image: %w", err)
+	}
+
+	if cfg.Width*cfg.Height > maxDecodePixels {
+		return nil
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("process image: %w", err)
+	}
+
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return fmt.Errorf("process image %v: %w: %v", img.Filename, ErrJobFailed, err)
+	}
+
+	err = writeThumbnail(img.ThumbnailPath, thumbnail(src, ThumbnailSize))
+	if err != nil {
+		return fmt.Errorf("process image: %w", err)
+	}
+
+	return nil
+}
+
+// writeThumbnail writes to a temporary file first so that a half written
+// thumbnail is never served.
+func writeThumbnail(path string, img image.Image) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".thumb-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	err = jpeg.Encode(tmp, img, &jpeg.Options{Quality: 85})
+	if err != nil {
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// thumbnail scales src down to fit within size pixels by averaging the
+// source pixels that each thumbnail pixel covers. Transparent areas are
+// flattened onto white because JPEG has no alpha channel.
+func thumbnail(src image.Image, size int) *image.RGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	tw, th := w, h
+	if w > size || h > size {
+		if w >= h {
+			tw, th = size, h*size/w
+		} else {
+			tw, th = w*size/h, size
+		}
+	}
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+
+	flat := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), src, b.Min, draw.Over)
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := y*h/th, (y+1)*h/th
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+
+		for x := 0; x < tw; x++ {
+			x0, x1 := x*w/tw, (x+1)*w/tw
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, bl, n int
+			for sy := y0; sy < y1; sy++ {
+				i := flat.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += int(flat.Pix[i])
+					g += int(flat.Pix[i+1])
+					bl += int(flat.Pix[i+2])
+					i += 4
+					n++
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(bl / n)
+			dst.Pix[i+3] = 0xff
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,249 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultJobMaxAttempts = 10
+	DefaultJobLease       = 10 * time.Minute
+	jobMinRetryDelay      = 15 * time.Second
+	jobMaxRetryDelay      = time.Hour
+)
+
+// Job kinds handled by the services in this package.
+const (
+	JobSendEmail            = "email.send"
+	JobProcessImage         = "image.process"
+	JobDeleteExpiredUploads = "uploads.delete_expired"
+	JobPasswordReset        = "password_reset.request"
+)
+
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	// JobDead jobs have used up their attempts. They are kept with the last
+	// error until they are retried by hand.
+	JobDead = "dead"
+)
+
+// ErrJobFailed can be wrapped by job handlers for errors that retrying won't
+// fix. The job is dead-lettered straight away.
+var ErrJobFailed = errors.New("models: job can't succeed")
+
+type Job struct {
+	ID          int
+	Kind        string
+	Payload     json.RawMessage
+	Attempts    int
+	MaxAttempts int
+	LastError   string
+}
+
+// Decode unmarshals the job payload into v.
+func (job *Job) Decode(v any) error {
+	err := json.Unmarshal(job.Payload, v)
+	if err != nil {
+		return fmt.Errorf("decode %v job %d: %w: %v", job.Kind, job.ID, ErrJobFailed, err)
+	}
+
+	return nil
+}
+
+// JobService stores background jobs in PostgreSQL. Any number of workers,
+// in any number of processes, can claim jobs concurrently; each job is only
+// handed to one of them at a time.
+type JobService struct {
+	DB          *sql.DB
+	MaxAttempts int
+	// Lease is how long a worker may hold a job. Running jobs older than
+	// this are assumed to belong to a worker that died and are claimed
+	// again.
+	Lease time.Duration
+}
+
+// Enqueue adds a job that runs as soon as a worker is free. The payload is
+// stored as JSON.
+func (service *JobService) Enqueue(kind string, payload any) error {
+	return service.Schedule(kind, "", payload, time.Time{})
+}
+
+// Schedule adds a job that runs no earlier than runAt. Jobs with a key are
+// only added if no other job with the same key is pending or running.
+func (service *JobService) Schedule(kind, key string, payload any, runAt time.Time) error {
+	return service.schedule(service.DB, kind, key, payload, runAt)
+}
+
+// schedule can run inside a transaction so that a job is only queued if the
+// rows it works on are committed.
+func (service *JobService) schedule(db dbtx, kind, key string, payload any, runAt time.Time) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("enqueue %v: %w", kind, err)
+	}
+
+	uniqueKey := sql.NullString{
+		String: key,
+		Valid:  key != "",
+	}
+	if runAt.IsZero() {
+		runAt = time.Now()
+	}
+
+	_, err = db.Exec(
+		`
+		INSERT INTO jobs (kind, unique_key, payload, max_attempts, run_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (unique_key) WHERE status IN ('pending', 'running') DO NOTHING;`,
+		kind,
+		uniqueKey,
+		string(b),
+		service.maxAttempts(),
+		runAt,
+	)
+	if err != nil {
+		return fmt.Errorf("enqueue %v: %w", kind, err)
+	}
+
+	return nil
+}
+
+// Claim locks the next runnable job of one of the given kinds and marks it
+// as running. It returns ErrNotFound when there is nothing to do. Rows that
+// other workers are claiming at the same moment are skipped rather than
+// waited for.
+func (service *JobService) Claim(kinds []string) (*Job, error) {
+	var job Job
+	var payload string
+	row := service.DB.QueryRow(
+		`
+		UPDATE jobs
+		SET status = 'running', locked_at = now(), attempts = attempts + 1
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE kind = ANY(string_to_array($1, ','))
+				AND ((status = 'pending' AND run_at <= now())
+					OR (status = 'running' AND locked_at < now() - make_interval(secs => $2)))
+			ORDER BY run_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, kind, payload, attempts, max_attempts, last_error;`,
+		strings.Join(kinds, ","),
+		service.lease().Seconds(),
+	)
+	err := row.Scan(&job.ID, &job.Kind, &payload, &job.Attempts, &job.MaxAttempts, &job.LastError)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("claim job: %w", err)
+	}
+	job.Payload = json.RawMessage(payload)
+
+	return &job, nil
+}
+
+// Complete removes a job that ran successfully.
+func (service *JobService) Complete(job *Job) error {
+	_, err := service.DB.Exec(
+		`
+		DELETE FROM jobs
+		WHERE id = $1;`,
+		job.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("complete job: %w", err)
+	}
+
+	return nil
+}
+
+// Fail records the error a job returned. The job is retried with an
+// exponential backoff until it runs out of attempts, after which it is
+// dead-lettered.
+func (service *JobService) Fail(job *Job, jobErr error) error {
+	job.LastError = jobErr.Error()
+
+	status := JobPending
+	if job.Attempts >= job.MaxAttempts || errors.Is(jobErr, ErrJobFailed) {
+		status = JobDead
+	}
+
+	_, err := service.DB.Exec(
+		`
+		UPDATE jobs
+		SET status = $2, last_error = $3, locked_at = NULL,
+			run_at = now() + make_interval(secs => $4)
+		WHERE id = $1;`,
+		job.ID,
+		status,
+		job.LastError,
+		retryDelay(job.Attempts).Seconds(),
+	)
+	if err != nil {
+		return fmt.Errorf("fail job: %w", err)
+	}
+
+	return nil
+}
+
+// RetryDead requeues every dead-lettered job with a fresh set of attempts.
+func (service *JobService) RetryDead() (int, error) {
+	result, err := service.DB.Exec(
+		`
+		UPDATE jobs
+		SET status = 'pending', attempts = 0, run_at = now()
+		WHERE status = 'dead';`,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("retry dead jobs: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("retry dead jobs: %w", err)
+	}
+
+	return int(n), nil
+}
+
+func (service *JobService) maxAttempts() int {
+	if service.MaxAttempts <= 0 {
+		return DefaultJobMaxAttempts
+	}
+
+	return service.MaxAttempts
+}
+
+func (service *JobService) lease() time.Duration {
+	if service.Lease <= 0 {
+		return DefaultJobLease
+	}
+
+	return service.Lease
+}
+
+// retryDelay doubles with every attempt, with up to 25% jitter so that jobs
+// that failed together don't all retry together.
+func retryDelay(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	delay := jobMaxRetryDelay
+	if attempts < 20 {
+		delay = jobMinRetryDelay << (attempts - 1)
+	}
+	if delay > jobMaxRetryDelay || delay <= 0 {
+		delay = jobMaxRetryDelay
+	}
+
+	return delay + time.Duration(rand.Int63n(int64(delay/4)+1))
+}
@@ -0,0 +1,144 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+type Likes struct {
+	Count int
+	// Liked is whether the user the likes were looked up for is one of
+	// them.
+	Liked bool
+}
+
+type LikeService struct {
+	DB *sql.DB
+}
+
+// ToggleGallery likes the gallery for the user, or takes their like back if
+// they already liked it. It reports whether the user likes the gallery
+// afterwards.
+func (service *LikeService) ToggleGallery(galleryID, userID int) (bool, error) {
+	result, err := service.DB.Exec(
+		`
+		DELETE FROM gallery_likes
+		WHERE gallery_id = $1 AND user_id = $2;`,
+		galleryID,
+		userID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("toggle gallery like: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("toggle gallery like: %w", err)
+	}
+	if n > 0 {
+		return false, nil
+	}
+
+	_, err = service.DB.Exec(
+		`
+		INSERT INTO gallery_likes (gallery_id, user_id)
+		VALUES ($1, $2) ON CONFLICT DO NOTHING;`,
+		galleryID,
+		userID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("toggle gallery like: %w", err)
+	}
+
+	return true, nil
+}
+
+// ToggleImage likes or unlikes an image like ToggleGallery.
+func (service *LikeService) ToggleImage(imageID, userID int) (bool, error) {
+	result, err := service.DB.Exec(
+		`
+		DELETE FROM image_likes
+		WHERE image_id = $1 AND user_id = $2;`,
+		imageID,
+		userID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("toggle image like: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("toggle image like: %w", err)
+	}
+	if n > 0 {
+		return false, nil
+	}
+
+	_, err = service.DB.Exec(
+		`
+		INSERT INTO image_likes (image_id, user_id)
+		VALUES ($1, $2) ON CONFLICT DO NOTHING;`,
+		imageID,
+		userID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("toggle image like: %w", err)
+	}
+
+	return true, nil
+}
+
+// Gallery returns the likes of a gallery. userID is zero for visitors who
+// aren't signed in.
+func (service *LikeService) Gallery(galleryID, userID int) (Likes, error) {
+	var likes Likes
+	err := service.DB.QueryRow(
+		`
+		SELECT count(*), COALESCE(bool_or(user_id = $2), false)
+		FROM gallery_likes
+		WHERE gallery_id = $1;`,
+		galleryID,
+		userID,
+	).Scan(&likes.Count, &likes.Liked)
+	if err != nil {
+		return Likes{}, fmt.Errorf("gallery likes: %w", err)
+	}
+
+	return likes, nil
+}
+
+// Images returns the likes of each image in the gallery that has any, by
+// image ID.
+func (service *LikeService) Images(galleryID, userID int) (map[int]Likes, error) {
+	rows, err := service.DB.Query(
+		`
+		SELECT image_likes.image_id, count(*), bool_or(image_likes.user_id = $2)
+		FROM image_likes
+			JOIN images ON images.id = image_likes.image_id
+		WHERE images.gallery_id = $1
+		GROUP BY image_likes.image_id;`,
+		galleryID,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("image likes: %w", err)
+	}
+	defer rows.Close()
+
+	likes := make(map[int]Likes)
+	for rows.Next() {
+		var imageID int
+		var l Likes
+		err = rows.Scan(&imageID, &l.Count, &l.Liked)
+		if err != nil {
+			return nil, fmt.Errorf("image likes: %w", err)
+		}
+		likes[imageID] = l
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("image likes: %w", rows.Err())
+	}
+
+	return likes, nil
+}
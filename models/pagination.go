@@ -0,0 +1,221 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	DefaultPageSize = 25
+	MaxPageSize     = 100
+)
+
+var (
+	ErrInvalidCursor = errors.New("models: invalid page cursor")
+	ErrInvalidSort   = errors.New("models: invalid sort order")
+)
+
+// PageRequest asks for the page of results that follows (or, for cursors
+// taken from Page.PrevCursor, precedes) the row the cursor points at. An
+// empty cursor requests the first page.
+type PageRequest struct {
+	Cursor string
+	Limit  int
+}
+
+type Page[T any] struct {
+	Items      []T
+	NextCursor string
+	PrevCursor string
+}
+
+// Sort describes the column a keyset paginated query is ordered by. Rows
+// with the same sort value are ordered by their ID.
+type Sort struct {
+	Column string
+	// Type is the PostgreSQL type of Column, used to cast cursor values.
+	Type string
+	Desc bool
+}
+
+// Sorts maps the public names of sort orders, such as "created" or
+// "-created" for descending, to the column they sort by.
+type Sorts map[string]Sort
+
+func (sorts Sorts) Lookup(name, fallback string) (Sort, error) {
+	if name == "" {
+		name = fallback
+	}
+
+	sort, ok := sorts[name]
+	if !ok {
+		return Sort{}, fmt.Errorf("%w: %q", ErrInvalidSort, name)
+	}
+
+	return sort, nil
+}
+
+type cursor struct {
+	Key    string `json:"k"`
+	ID     int    `json:"i"`
+	Before bool   `json:"b,omitempty"`
+}
+
+func (c cursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c cursor
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
+
+// timestamptzLayouts are the formats PostgreSQL uses when casting a
+// timestamptz to text, with and without minutes in the UTC offset.
+var timestamptzLayouts = []string{
+	"2006-01-02 15:04:05.999999-07",
+	"2006-01-02 15:04:05.999999-07:00",
+}
+
+// validKey reports whether key can be cast to the sort's type, so that a
+// tampered cursor is rejected rather than failing the query.
+func validKey(typ, key string) bool {
+	switch typ {
+	case "timestamptz":
+		for _, layout := range timestamptzLayouts {
+			_, err := time.Parse(layout, key)
+			if err == nil {
+				return true
+			}
+		}
+		return false
+	case "integer", "bigint":
+		_, err := strconv.ParseInt(key, 10, 64)
+		return err == nil
+	default:
+		return utf8.ValidString(key) && !strings.ContainsRune(key, 0)
+	}
+}
+
+// keyset builds the SQL for one page of a keyset paginated query and turns
+// the rows it returns into a Page.
+type keyset struct {
+	sort   Sort
+	cursor *cursor
+	limit  int
+}
+
+func newKeyset(sort Sort, req PageRequest) (keyset, error) {
+	c, err := decodeCursor(req.Cursor)
+	if err != nil {
+		return keyset{}, err
+	}
+	if c != nil && !validKey(sort.Type, c.Key) {
+		return keyset{}, ErrInvalidCursor
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	return keyset{
+		sort:   sort,
+		cursor: c,
+		limit:  limit,
+	}, nil
+}
+
+func (k keyset) backward() bool {
+	return k.cursor != nil && k.cursor.Before
+}
+
+// selectKey is the expression to select alongside each row so that cursors
+// can be built from it.
+func (k keyset) selectKey() string {
+	return fmt.Sprintf("(%s)::text", k.sort.Column)
+}
+
+// where returns the condition that skips rows up to the cursor, using
+// placeholders numbered from argN, along with its arguments.
+func (k keyset) where(idColumn string, argN int) (string, []any) {
+	if k.cursor == nil {
+		return "TRUE", nil
+	}
+
+	op := ">"
+	if k.sort.Desc != k.backward() {
+		op = "<"
+	}
+
+	cond := fmt.Sprintf("(%s, %s) %s ($%d::%s, $%d)", k.sort.Column, idColumn, op, argN, k.sort.Type, argN+1)
+	return cond, []any{k.cursor.Key, k.cursor.ID}
+}
+
+// orderBy returns the ORDER BY and LIMIT clauses. One extra row is fetched
+// to find out whether there is another page.
+func (k keyset) orderBy(idColumn string) string {
+	dir := "ASC"
+	if k.sort.Desc != k.backward() {
+		dir = "DESC"
+	}
+
+	return fmt.Sprintf("ORDER BY %s %s, %s %s LIMIT %d", k.sort.Column, dir, idColumn, dir, k.limit+1)
+}
+
+func newPage[T any](k keyset, items []T, keys []cursor) *Page[T] {
+	hasMore := len(items) > k.limit
+	if hasMore {
+		items = items[:k.limit]
+		keys = keys[:k.limit]
+	}
+
+	if k.backward() {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+
+	page := Page[T]{
+		Items: items,
+	}
+	if len(items) == 0 {
+		return &page
+	}
+
+	if (!k.backward() && hasMore) || k.backward() {
+		page.NextCursor = keys[len(keys)-1].encode()
+	}
+	if (k.backward() && hasMore) || (!k.backward() && k.cursor != nil) {
+		first := keys[0]
+		first.Before = true
+		page.PrevCursor = first.encode()
+	}
+
+	return &page
+}
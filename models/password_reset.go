@@ -4,7 +4,9 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"net/url"
 	"strings"
 	"time"
 
@@ -27,6 +29,66 @@ type PasswordResetService struct {
 	DB            *sql.DB
 	BytesPerToken int
 	Duration      time.Duration
+	EmailService  *EmailService
+	// Jobs runs password reset requests in the background. They run
+	// straight away when it is nil.
+	Jobs *JobService
+}
+
+type PasswordResetRequest struct {
+	Email  string
+	Locale string
+	// ResetURL is the page the emailed link points at. The token is added
+	// to it as a query parameter.
+	ResetURL string
+}
+
+// Request emails a password reset link if the address belongs to a user,
+// and silently does nothing otherwise. With Jobs set, the lookup happens
+// in a background job so that the request takes the same time either way.
+func (service *PasswordResetService) Request(req PasswordResetRequest) error {
+	if service.Jobs == nil {
+		return service.request(req)
+	}
+
+	err := service.Jobs.Enqueue(JobPasswordReset, req)
+	if err != nil {
+		return fmt.Errorf("request password reset: %w", err)
+	}
+
+	return nil
+}
+
+// RequestJob handles JobPasswordReset jobs.
+func (service *PasswordResetService) RequestJob(job *Job) error {
+	var req PasswordResetRequest
+	err := job.Decode(&req)
+	if err != nil {
+		return err
+	}
+
+	return service.request(req)
+}
+
+func (service *PasswordResetService) request(req PasswordResetRequest) error {
+	pwReset, err := service.Create(req.Email)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("request password reset: %w", err)
+	}
+
+	vals := url.Values{
+		"token": {pwReset.Token},
+	}
+
+	err = service.EmailService.ForgotPassword(req.Email, req.Locale, req.ResetURL+"?"+vals.Encode())
+	if err != nil {
+		return fmt.Errorf("request password reset: %w", err)
+	}
+
+	return nil
 }
 
 func (service *PasswordResetService) Create(email string) (*PasswordReset, error) {
@@ -38,6 +100,9 @@ func (service *PasswordResetService) Create(email string) (*PasswordReset, error
 		email)
 	err := row.Scan(&userID)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("create: %w", ErrNotFound)
+		}
 		return nil, fmt.Errorf("create: %w", err)
 	}
 
@@ -106,11 +171,14 @@ func (service *PasswordResetService) Consume(token string) (*User, error) {
 		&user.PasswordHash,
 	)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("consume: %w", ErrNotFound)
+		}
 		return nil, fmt.Errorf("consume: %w", err)
 	}
 
 	if time.Now().After(pwReset.ExpiresAt) {
-		return nil, fmt.Errorf("token expired: %v", token)
+		return nil, fmt.Errorf("consume: %w", ErrTokenExpired)
 	}
 
 	err = service.delete(pwReset.ID)
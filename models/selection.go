@@ -0,0 +1,355 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/IrakliGiorgadze/go-web-app/rand"
+)
+
+const (
+	// MaxSelectionsPerWindow is how many selections a client, such as an
+	// IP address, can submit from a gallery within SelectionWindow.
+	MaxSelectionsPerWindow = 5
+	SelectionWindow        = time.Hour
+)
+
+// Selection is a set of favourite images a visitor picked from a gallery,
+// such as a client choosing shots for retouching. It is a draft that only
+// its token can change until it is submitted to the owner.
+type Selection struct {
+	ID        int
+	GalleryID int
+	// UserID is zero for visitors without an account.
+	UserID    int
+	Token     string
+	TokenHash string
+	Name      string
+	Comment   string
+	// SubmittedAt is zero for drafts.
+	SubmittedAt time.Time
+	CreatedAt   time.Time
+	Images      []SelectionImage
+}
+
+type SelectionImage struct {
+	ImageID  int
+	Filename string
+	Name     string
+	Note     string
+}
+
+// Has reports whether the image is one of the selection's favourites.
+func (s *Selection) Has(imageID int) bool {
+	for _, image := range s.Images {
+		if image.ImageID == imageID {
+			return true
+		}
+	}
+
+	return false
+}
+
+type SelectionService struct {
+	DB            *sql.DB
+	BytesPerToken int
+}
+
+// Create starts an empty draft selection from the gallery.
+func (service *SelectionService) Create(galleryID, userID int) (*Selection, error) {
+	bytesPerToken := service.BytesPerToken
+	if bytesPerToken < MinBytesPerToken {
+		bytesPerToken = MinBytesPerToken
+	}
+
+	token, err := rand.String(bytesPerToken)
+	if err != nil {
+		return nil, fmt.Errorf("create selection: %w", err)
+	}
+
+	selection := Selection{
+		GalleryID: galleryID,
+		UserID:    userID,
+		Token:     token,
+		TokenHash: service.hash(token),
+	}
+
+	row := service.DB.QueryRow(
+		`
+		INSERT INTO selections (gallery_id, user_id, token_hash)
+		VALUES ($1, NULLIF($2, 0), $3) RETURNING id, created_at;`,
+		selection.GalleryID,
+		selection.UserID,
+		selection.TokenHash,
+	)
+	err = row.Scan(&selection.ID, &selection.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("create selection: %w", err)
+	}
+
+	return &selection, nil
+}
+
+// Draft returns the draft selection from the gallery with token. Once it
+// has been submitted it can't be found this way.
+func (service *SelectionService) Draft(galleryID int, token string) (*Selection, error) {
+	selection := Selection{
+		GalleryID: galleryID,
+		Token:     token,
+		TokenHash: service.hash(token),
+	}
+
+	row := service.DB.QueryRow(
+		`
+		SELECT id, COALESCE(user_id, 0), name, comment, created_at
+		FROM selections
+		WHERE gallery_id = $1 AND token_hash = $2 AND submitted_at IS NULL;`,
+		selection.GalleryID,
+		selection.TokenHash,
+	)
+	err := row.Scan(&selection.ID, &selection.UserID, &selection.Name, &selection.Comment, &selection.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("draft selection: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("draft selection: %w", err)
+	}
+
+	selection.Images, err = service.images(selection.ID)
+	if err != nil {
+		return nil, fmt.Errorf("draft selection: %w", err)
+	}
+
+	return &selection, nil
+}
+
+// Toggle adds the image to the selection's favourites, or removes it if it
+// is already one. It reports whether the image is a favourite afterwards.
+func (service *SelectionService) Toggle(selectionID, imageID int) (bool, error) {
+	result, err := service.DB.Exec(
+		`
+		DELETE FROM selection_images
+		WHERE selection_id = $1 AND image_id = $2;`,
+		selectionID,
+		imageID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("toggle favorite: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("toggle favorite: %w", err)
+	}
+	if n > 0 {
+		return false, nil
+	}
+
+	_, err = service.DB.Exec(
+		`
+		INSERT INTO selection_images (selection_id, image_id)
+		VALUES ($1, $2) ON CONFLICT DO NOTHING;`,
+		selectionID,
+		imageID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("toggle favorite: %w", err)
+	}
+
+	return true, nil
+}
+
+// Submit saves the name, comment and image notes of a draft and sends it to
+// the owner, after which it can no longer be changed. A client that has
+// submitted MaxSelectionsPerWindow selections from the gallery in the last
+// SelectionWindow gets ErrTooManySelections.
+func (service *SelectionService) Submit(selection *Selection, client string) error {
+	tx, err := service.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("submit selection: %w", err)
+	}
+	defer tx.Rollback()
+
+	// The lock makes concurrent submissions from the client wait, so that
+	// they can't all get in under the limit.
+	_, err = tx.Exec(
+		`
+		SELECT pg_advisory_xact_lock($1, hashtext($2));`,
+		selection.GalleryID,
+		client,
+	)
+	if err != nil {
+		return fmt.Errorf("submit selection: %w", err)
+	}
+
+	var recent int
+	err = tx.QueryRow(
+		`
+		SELECT count(*)
+		FROM selections
+		WHERE gallery_id = $1 AND client = $2 AND submitted_at > $3;`,
+		selection.GalleryID,
+		client,
+		time.Now().Add(-SelectionWindow),
+	).Scan(&recent)
+	if err != nil {
+		return fmt.Errorf("submit selection: %w", err)
+	}
+	if recent >= MaxSelectionsPerWindow {
+		return fmt.Errorf("submit selection: %w", ErrTooManySelections)
+	}
+
+	row := tx.QueryRow(
+		`
+		UPDATE selections
+		SET name = $2, comment = $3, client = $4, submitted_at = now()
+		WHERE id = $1 AND submitted_at IS NULL
+		RETURNING submitted_at;`,
+		selection.ID,
+		selection.Name,
+		selection.Comment,
+		client,
+	)
+	err = row.Scan(&selection.SubmittedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("submit selection: %w", ErrNotFound)
+		}
+		return fmt.Errorf("submit selection: %w", err)
+	}
+
+	for _, image := range selection.Images {
+		_, err = tx.Exec(
+			`
+			UPDATE selection_images
+			SET note = $3
+			WHERE selection_id = $1 AND image_id = $2;`,
+			selection.ID,
+			image.ImageID,
+			image.Note,
+		)
+		if err != nil {
+			return fmt.Errorf("submit selection: %w", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("submit selection: %w", err)
+	}
+
+	return nil
+}
+
+// Submitted lists the selections sent to the owner of a gallery, newest
+// first.
+func (service *SelectionService) Submitted(galleryID int) ([]Selection, error) {
+	rows, err := service.DB.Query(
+		`
+		SELECT id, COALESCE(user_id, 0), name, comment, submitted_at, created_at
+		FROM selections
+		WHERE gallery_id = $1 AND submitted_at IS NOT NULL
+		ORDER BY submitted_at DESC, id DESC;`,
+		galleryID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("submitted selections: %w", err)
+	}
+	defer rows.Close()
+
+	var selections []Selection
+	for rows.Next() {
+		selection := Selection{
+			GalleryID: galleryID,
+		}
+		err = rows.Scan(&selection.ID, &selection.UserID, &selection.Name, &selection.Comment,
+			&selection.SubmittedAt, &selection.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("submitted selections: %w", err)
+		}
+		selections = append(selections, selection)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("submitted selections: %w", rows.Err())
+	}
+
+	for i := range selections {
+		selections[i].Images, err = service.images(selections[i].ID)
+		if err != nil {
+			return nil, fmt.Errorf("submitted selections: %w", err)
+		}
+	}
+
+	return selections, nil
+}
+
+// ByID returns a submitted selection from the gallery.
+func (service *SelectionService) ByID(galleryID, id int) (*Selection, error) {
+	selection := Selection{
+		ID:        id,
+		GalleryID: galleryID,
+	}
+
+	row := service.DB.QueryRow(
+		`
+		SELECT COALESCE(user_id, 0), name, comment, submitted_at, created_at
+		FROM selections
+		WHERE id = $1 AND gallery_id = $2 AND submitted_at IS NOT NULL;`,
+		selection.ID,
+		selection.GalleryID,
+	)
+	err := row.Scan(&selection.UserID, &selection.Name, &selection.Comment, &selection.SubmittedAt, &selection.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("selection by id: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("selection by id: %w", err)
+	}
+
+	selection.Images, err = service.images(selection.ID)
+	if err != nil {
+		return nil, fmt.Errorf("selection by id: %w", err)
+	}
+
+	return &selection, nil
+}
+
+// images lists the favourites of a selection in the order they appear in
+// the gallery.
+func (service *SelectionService) images(selectionID int) ([]SelectionImage, error) {
+	rows, err := service.DB.Query(
+		`
+		SELECT images.id, images.filename, images.name, selection_images.note
+		FROM selection_images
+			JOIN images ON images.id = selection_images.image_id
+		WHERE selection_images.selection_id = $1
+		ORDER BY images.position, images.id;`,
+		selectionID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []SelectionImage
+	for rows.Next() {
+		var image SelectionImage
+		err = rows.Scan(&image.ImageID, &image.Filename, &image.Name, &image.Note)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+
+	return images, rows.Err()
+}
+
+func (service *SelectionService) hash(token string) string {
+	tokenHash := sha256.Sum256([]byte(token))
+	return base64.URLEncoding.EncodeToString(tokenHash[:])
+}
@@ -69,13 +69,19 @@ func (ss *SessionService) User(token string) (*User, error) {
 		`
 		SELECT users.id,
       		users.email,
-      		users.password_hash
+      		users.password_hash,
+      		users.locale,
+      		COALESCE(users.username, ''),
+      		users.display_name,
+      		users.bio,
+      		users.avatar
     	FROM sessions
       		JOIN users ON users.id = sessions.user_id
     	WHERE sessions.token_hash = $1;`,
 		tokenHash,
 	)
-	err := row.Scan(&user.ID, &user.Email, &user.PasswordHash)
+	err := row.Scan(&user.ID, &user.Email, &user.PasswordHash, &user.Locale,
+		&user.Username, &user.DisplayName, &user.Bio, &user.Avatar)
 	if err != nil {
 		return nil, fmt.Errorf("user: %w", err)
 	}
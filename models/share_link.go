@@ -0,0 +1,230 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/IrakliGiorgadze/go-web-app/rand"
+)
+
+// ShareLink lets whoever has it see a gallery without an account, even one
+// with a password.
+type ShareLink struct {
+	ID        int
+	GalleryID int
+	// Label tells the owner who the link was made for.
+	Label     string
+	Token     string
+	TokenHash string
+	// AllowDownloads lets visitors download the original images, on their
+	// own or as a ZIP archive. Without it they only see thumbnails.
+	AllowDownloads bool
+	Views          int
+	Downloads      int
+	CreatedBy      int
+	// ExpiresAt and RevokedAt are zero for links that never expire and
+	// haven't been revoked.
+	ExpiresAt time.Time
+	RevokedAt time.Time
+	CreatedAt time.Time
+}
+
+// Active reports whether the link still works.
+func (link ShareLink) Active() bool {
+	return link.RevokedAt.IsZero() && !link.Expired()
+}
+
+func (link ShareLink) Expired() bool {
+	return !link.ExpiresAt.IsZero() && time.Now().After(link.ExpiresAt)
+}
+
+// ShareLinkService issues share links. Like sessions, only a hash of each
+// token is stored, so the links can't be rebuilt from the database.
+type ShareLinkService struct {
+	DB            *sql.DB
+	BytesPerToken int
+}
+
+// Create issues a new link with the gallery, label, expiry and download
+// setting of link, and fills in its ID and Token.
+func (service *ShareLinkService) Create(link *ShareLink) error {
+	bytesPerToken := service.BytesPerToken
+	if bytesPerToken < MinBytesPerToken {
+		bytesPerToken = MinBytesPerToken
+	}
+
+	token, err := rand.String(bytesPerToken)
+	if err != nil {
+		return fmt.Errorf("create share link: %w", err)
+	}
+	link.Token = token
+	link.TokenHash = service.hash(token)
+
+	row := service.DB.QueryRow(
+		`
+		INSERT INTO share_links (gallery_id, label, token_hash, allow_downloads, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, 0), $6) RETURNING id, created_at;`,
+		link.GalleryID,
+		link.Label,
+		link.TokenHash,
+		link.AllowDownloads,
+		link.CreatedBy,
+		nullTime(link.ExpiresAt),
+	)
+	err = row.Scan(&link.ID, &link.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("create share link: %w", err)
+	}
+
+	return nil
+}
+
+// ByGallery lists every link made for a gallery, newest first, including
+// the ones that no longer work.
+func (service *ShareLinkService) ByGallery(galleryID int) ([]ShareLink, error) {
+	rows, err := service.DB.Query(
+		`
+		SELECT id, label, allow_downloads, views, downloads, COALESCE(created_by, 0),
+			expires_at, revoked_at, created_at
+		FROM share_links
+		WHERE gallery_id = $1
+		ORDER BY created_at DESC, id DESC;`,
+		galleryID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("share links: %w", err)
+	}
+	defer rows.Close()
+
+	var links []ShareLink
+	for rows.Next() {
+		link := ShareLink{
+			GalleryID: galleryID,
+		}
+		var expiresAt, revokedAt sql.NullTime
+		err = rows.Scan(&link.ID, &link.Label, &link.AllowDownloads, &link.Views, &link.Downloads, &link.CreatedBy,
+			&expiresAt, &revokedAt, &link.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("share links: %w", err)
+		}
+		link.ExpiresAt = expiresAt.Time
+		link.RevokedAt = revokedAt.Time
+		links = append(links, link)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("share links: %w", rows.Err())
+	}
+
+	return links, nil
+}
+
+// ByToken looks up the link with token. It returns ErrNotFound for links
+// that don't exist or were revoked, and ErrTokenExpired for expired ones.
+func (service *ShareLinkService) ByToken(token string) (*ShareLink, error) {
+	link := ShareLink{
+		Token:     token,
+		TokenHash: service.hash(token),
+	}
+
+	var expiresAt, revokedAt sql.NullTime
+	row := service.DB.QueryRow(
+		`
+		SELECT id, gallery_id, label, allow_downloads, views, downloads, COALESCE(created_by, 0),
+			expires_at, revoked_at, created_at
+		FROM share_links
+		WHERE token_hash = $1;`,
+		link.TokenHash,
+	)
+	err := row.Scan(&link.ID, &link.GalleryID, &link.Label, &link.AllowDownloads, &link.Views, &link.Downloads, &link.CreatedBy,
+		&expiresAt, &revokedAt, &link.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("share link by token: %w", ErrNotFound)
+		}
+		return nil, fmt.Errorf("share link by token: %w", err)
+	}
+	link.ExpiresAt = expiresAt.Time
+	link.RevokedAt = revokedAt.Time
+
+	if !link.RevokedAt.IsZero() {
+		return nil, fmt.Errorf("share link by token: %w", ErrNotFound)
+	}
+	if link.Expired() {
+		return nil, fmt.Errorf("share link by token: %w", ErrTokenExpired)
+	}
+
+	return &link, nil
+}
+
+// Revoke stops a link from working. It is kept, with its counts, so that
+// the owner can still see how it was used.
+func (service *ShareLinkService) Revoke(galleryID, id int) error {
+	result, err := service.DB.Exec(
+		`
+		UPDATE share_links
+		SET revoked_at = now()
+		WHERE gallery_id = $1 AND id = $2 AND revoked_at IS NULL;`,
+		galleryID,
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("revoke share link: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("revoke share link: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("revoke share link: %w", ErrNotFound)
+	}
+
+	return nil
+}
+
+// CountView records that the gallery was viewed through the link.
+func (service *ShareLinkService) CountView(id int) error {
+	_, err := service.DB.Exec(
+		`
+		UPDATE share_links
+		SET views = views + 1
+		WHERE id = $1;`,
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("count share link view: %w", err)
+	}
+
+	return nil
+}
+
+// CountDownload records that an original image or the ZIP archive was
+// downloaded through the link.
+func (service *ShareLinkService) CountDownload(id int) error {
+	_, err := service.DB.Exec(
+		`
+		UPDATE share_links
+		SET downloads = downloads + 1
+		WHERE id = $1;`,
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("count share link download: %w", err)
+	}
+
+	return nil
+}
+
+func (service *ShareLinkService) hash(token string) string {
+	tokenHash := sha256.Sum256([]byte(token))
+	return base64.URLEncoding.EncodeToString(tokenHash[:])
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
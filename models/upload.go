@@ -0,0 +1,393 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/IrakliGiorgadze/go-web-app/rand"
+)
+
+const (
+	DefaultUploadDuration = 24 * time.Hour
+)
+
+var (
+	ErrUploadExpired  = errors.New("models: upload has expired")
+	ErrUploadConflict = errors.New("models: upload offset does not match")
+	// ErrUploadLeaseExpired means a chunk took longer than
+	// UploadLeaseDuration to arrive.
+	ErrUploadLeaseExpired = errors.New("models: upload lease expired")
+)
+
+type Upload struct {
+	ID        int
+	GalleryID int
+	UserID    int
+	Token     string
+	TokenHash string
+	Filename  string
+	Size      int64
+	Received  int64
+	ExpiresAt time.Time
+}
+
+func (u *Upload) Complete() bool {
+	return u.Received >= u.Size
+}
+
+// UploadService stages resumable uploads on disk until every byte has been
+// received, then hands the assembled file to the GalleryService.
+type UploadService struct {
+	DB             *sql.DB
+	GalleryService *GalleryService
+	StagingDir     string
+	BytesPerToken  int
+	Duration       time.Duration
+}
+
+func (service *UploadService) Create(galleryID, userID int, filename string, size int64) (*Upload, error) {
+	err := checkExtension(filename, service.GalleryService.extensions())
+	if err != nil {
+		return nil, fmt.Errorf("create upload: %w", err)
+	}
+
+	bytesPerToken := service.BytesPerToken
+	if bytesPerToken < MinBytesPerToken {
+		bytesPerToken = MinBytesPerToken
+	}
+
+	token, err := rand.String(bytesPerToken)
+	if err != nil {
+		return nil, fmt.Errorf("create upload: %w", err)
+	}
+
+	upload := Upload{
+		GalleryID: galleryID,
+		UserID:    userID,
+		Token:     token,
+		TokenHash: service.hash(token),
+		Filename:  filepath.Base(filename),
+		Size:      size,
+		ExpiresAt: time.Now().Add(service.duration()),
+	}
+
+	row := service.DB.QueryRow(
+		`
+		INSERT INTO uploads (gallery_id, user_id, token_hash, filename, size, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;`,
+		upload.GalleryID,
+		upload.UserID,
+		upload.TokenHash,
+		upload.Filename,
+		upload.Size,
+		upload.ExpiresAt,
+	)
+	err = row.Scan(&upload.ID)
+	if err != nil {
+		return nil, fmt.Errorf("create upload: %w", err)
+	}
+
+	err = os.MkdirAll(service.stagingDir(), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("create upload staging directory: %w", err)
+	}
+
+	f, err := os.Create(service.stagingPath(upload.ID))
+	if err != nil {
+		return nil, fmt.Errorf("create upload staging file: %w", err)
+	}
+	f.Close()
+
+	return &upload, nil
+}
+
+func (service *UploadService) ByToken(galleryID int, token string) (*Upload, error) {
+	upload := Upload{
+		GalleryID: galleryID,
+		Token:     token,
+		TokenHash: service.hash(token),
+	}
+
+	row := service.DB.QueryRow(
+		`
+		SELECT id, user_id, filename, size, received, expires_at
+		FROM uploads
+		WHERE token_hash = $1 AND gallery_id = $2;`,
+		upload.TokenHash,
+		upload.GalleryID,
+	)
+	err := row.Scan(&upload.ID, &upload.UserID, &upload.Filename, &upload.Size, &upload.Received, &upload.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("query upload by token: %w", err)
+	}
+
+	if time.Now().After(upload.ExpiresAt) {
+		return nil, ErrUploadExpired
+	}
+
+	return &upload, nil
+}
+
+// UploadLeaseDuration is how long a request has to send its chunk. No
+// other request can append to the upload meanwhile, and bytes that arrive
+// after the lease has run out are dropped.
+const UploadLeaseDuration = 10 * time.Minute
+
+// Append writes a chunk starting at offset. Bytes that arrive before a
+// connection drops are kept so the client can resume from the new offset.
+// Once the final byte is received the file is validated and stored as a
+// gallery image, and the staged upload is removed.
+//
+// The chunk is read from the client outside of any transaction, so a slow
+// client holds neither a connection nor a row lock. Instead the request
+// takes a lease on the upload, and only the request holding it can record
+// the bytes it received.
+func (service *UploadService) Append(upload *Upload, offset int64, chunk io.Reader) error {
+	lease, err := rand.String(MinBytesPerToken)
+	if err != nil {
+		return fmt.Errorf("append upload: %w", err)
+	}
+	leasedUntil := time.Now().Add(UploadLeaseDuration)
+
+	result, err := service.DB.Exec(
+		`
+		UPDATE uploads
+		SET lease = $3, leased_until = $4
+		WHERE id = $1 AND received = $2 AND (leased_until IS NULL OR leased_until < now());`,
+		upload.ID,
+		offset,
+		lease,
+		leasedUntil,
+	)
+	if err != nil {
+		return fmt.Errorf("append upload: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("append upload: %w", err)
+	}
+	if n == 0 {
+		return ErrUploadConflict
+	}
+
+	f, err := service.openAt(upload.ID, offset)
+	if err != nil {
+		service.releaseLease(upload.ID, lease)
+		return fmt.Errorf("append upload: %w", err)
+	}
+	defer f.Close()
+
+	written, copyErr := io.Copy(f, &leaseReader{
+		r:     io.LimitReader(chunk, upload.Size-offset),
+		until: leasedUntil,
+	})
+	received := offset + written
+	expiresAt := time.Now().Add(service.duration())
+
+	result, err = service.DB.Exec(
+		`
+		UPDATE uploads
+		SET received = $4, expires_at = $5, lease = '', leased_until = NULL
+		WHERE id = $1 AND lease = $2 AND received = $3;`,
+		upload.ID,
+		lease,
+		offset,
+		received,
+		expiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("append upload: %w", err)
+	}
+	n, err = result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("append upload: %w", err)
+	}
+	if n == 0 {
+		// The lease ran out and another request took over the upload.
+		return ErrUploadConflict
+	}
+	upload.Received = received
+	upload.ExpiresAt = expiresAt
+
+	if copyErr != nil {
+		return fmt.Errorf("append upload: %w", copyErr)
+	}
+
+	if !upload.Complete() {
+		return nil
+	}
+
+	return service.assemble(upload)
+}
+
+// openAt opens the staged file of an upload for writing at offset,
+// dropping anything written past it by an earlier request.
+func (service *UploadService) openAt(id int, offset int64) (*os.File, error) {
+	f, err := os.OpenFile(service.stagingPath(id), os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.Truncate(offset)
+	if err == nil {
+		_, err = f.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// releaseLease lets the next request append to the upload straight away
+// rather than after the lease has run out.
+func (service *UploadService) releaseLease(id int, lease string) {
+	_, err := service.DB.Exec(
+		`
+		UPDATE uploads
+		SET lease = '', leased_until = NULL
+		WHERE id = $1 AND lease = $2;`,
+		id,
+		lease,
+	)
+	if err != nil {
+		log.Printf("releasing the lease on upload %d: %v", id, err)
+	}
+}
+
+// leaseReader stops reading once the lease on an upload has run out, so
+// that a request can't write to the staged file after another one has
+// taken over.
+type leaseReader struct {
+	r     io.Reader
+	until time.Time
+}
+
+func (lr *leaseReader) Read(p []byte) (int, error) {
+	if time.Now().After(lr.until) {
+		return 0, ErrUploadLeaseExpired
+	}
+
+	return lr.r.Read(p)
+}
+
+func (service *UploadService) assemble(upload *Upload) error {
+	defer func() {
+		err := service.Delete(upload)
+		if err != nil {
+			log.Printf("deleting upload %d: %v", upload.ID, err)
+		}
+	}()
+
+	f, err := os.Open(service.stagingPath(upload.ID))
+	if err != nil {
+		return fmt.Errorf("assemble upload: %w", err)
+	}
+	defer f.Close()
+
+	_, err = service.GalleryService.CreateImage(upload.GalleryID, upload.UserID, upload.Filename, f)
+	if err != nil {
+		return fmt.Errorf("assemble upload: %w", err)
+	}
+
+	return nil
+}
+
+func (service *UploadService) Delete(upload *Upload) error {
+	_, err := service.DB.Exec(
+		`
+		DELETE FROM uploads
+		WHERE id = $1;`,
+		upload.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("delete upload: %w", err)
+	}
+
+	err = os.Remove(service.stagingPath(upload.ID))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("delete upload: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteExpired removes partial uploads that have not received any data
+// within the upload duration. It returns the number of uploads removed.
+func (service *UploadService) DeleteExpired() (int, error) {
+	rows, err := service.DB.Query(
+		`
+		DELETE FROM uploads
+		WHERE expires_at < $1
+		RETURNING id;`,
+		time.Now(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("delete expired uploads: %w", err)
+	}
+	defer rows.Close()
+
+	var count int
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			return count, fmt.Errorf("delete expired uploads: %w", err)
+		}
+
+		err = os.Remove(service.stagingPath(id))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return count, fmt.Errorf("delete expired uploads: %w", err)
+		}
+		count++
+	}
+
+	if rows.Err() != nil {
+		return count, fmt.Errorf("delete expired uploads: %w", rows.Err())
+	}
+
+	return count, nil
+}
+
+func (service *UploadService) duration() time.Duration {
+	if service.Duration == 0 {
+		return DefaultUploadDuration
+	}
+
+	return service.Duration
+}
+
+func (service *UploadService) stagingDir() string {
+	if service.StagingDir == "" {
+		return "uploads"
+	}
+
+	return service.StagingDir
+}
+
+func (service *UploadService) stagingPath(id int) string {
+	return filepath.Join(service.stagingDir(), fmt.Sprintf("upload-%d", id))
+}
+
+func (service *UploadService) hash(token string) string {
+	tokenHash := sha256.Sum256([]byte(token))
+	return base64.URLEncoding.EncodeToString(tokenHash[:])
+}
+
+// DeleteExpiredJob handles JobDeleteExpiredUploads jobs.
+func (service *UploadService) DeleteExpiredJob(job *Job) error {
+	_, err := service.DeleteExpired()
+	return err
+}
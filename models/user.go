@@ -15,10 +15,32 @@ type User struct {
 	ID           int
 	Email        string
 	PasswordHash string
+	// Locale is the language the user chose for the site, or empty to go
+	// by their browser's settings.
+	Locale string
+	// Username is empty until the user picks one, and until then they have
+	// no profile page.
+	Username    string
+	DisplayName string
+	Bio         string
+	// Avatar is the filename of the user's avatar, or empty if they haven't
+	// uploaded one.
+	Avatar string
+}
+
+// Name is what the user is called on the site: their display name, falling
+// back to their username.
+func (u User) Name() string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+
+	return u.Username
 }
 
 type UserService struct {
-	DB *sql.DB
+	DB        *sql.DB
+	ImagesDir string
 }
 
 func (us *UserService) Create(email, password string) (*User, error) {
@@ -57,6 +79,9 @@ func (us *UserService) Create(email, password string) (*User, error) {
 	return &user, nil
 }
 
+// Authenticate returns ErrInvalidCredentials for both unknown emails and
+// wrong passwords, and takes about as long for either so that response
+// times don't reveal which addresses have accounts.
 func (us *UserService) Authenticate(email, password string) (*User, error) {
 	email = strings.ToLower(email)
 	user := User{
@@ -70,13 +95,19 @@ func (us *UserService) Authenticate(email, password string) (*User, error) {
 
 	err := row.Scan(&user.ID, &user.PasswordHash)
 	if err != nil {
-		return nil, fmt.Errorf("autheticate user: %w", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+			return nil, fmt.Errorf("authenticate user: %w", ErrInvalidCredentials)
+		}
+		return nil, fmt.Errorf("authenticate user: %w", err)
 	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
 	if err != nil {
-		fmt.Printf("Password is invalid: %v\n", password)
-		return nil, fmt.Errorf("autheticate user: %w", err)
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return nil, fmt.Errorf("authenticate user: %w", ErrInvalidCredentials)
+		}
+		return nil, fmt.Errorf("authenticate user: %w", err)
 	}
 
 	return &user, nil
@@ -103,3 +134,24 @@ func (us *UserService) UpdatePassword(userID int, password string) error {
 
 	return nil
 }
+
+func (us *UserService) UpdateLocale(userID int, locale string) error {
+	_, err := us.DB.Exec(
+		`
+		UPDATE users
+		SET locale = $2
+		WHERE id = $1;`,
+		userID,
+		locale,
+	)
+	if err != nil {
+		return fmt.Errorf("update locale: %w", err)
+	}
+
+	return nil
+}
+
+// dummyPasswordHash is compared against when there is no user, so that the
+// same bcrypt work is done as for a real account. It is made when the
+// program starts, as making it on first use would slow that sign in down.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
@@ -0,0 +1,354 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/IrakliGiorgadze/go-web-app/rand"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+)
+
+const (
+	MinUsernameLength = 3
+	MaxUsernameLength = 30
+	// AvatarSize is the width and height avatars are cropped and scaled to.
+	AvatarSize = 256
+	// MaxUsernameRedirects is how many of their old usernames a user keeps.
+	// Older ones are released so that nobody can hold on to a lot of names
+	// by changing theirs over and over.
+	MaxUsernameRedirects = 3
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// reservedUsernames name parts of the site, or could be mistaken for the
+// people running it.
+var reservedUsernames = map[string]bool{
+	"about":         true,
+	"admin":         true,
+	"administrator": true,
+	"api":           true,
+	"assets":        true,
+	"contact":       true,
+	"explore":       true,
+	"faq":           true,
+	"galleries":     true,
+	"help":          true,
+	"images":        true,
+	"locale":        true,
+	"login":         true,
+	"logout":        true,
+	"me":            true,
+	"moderator":     true,
+	"root":          true,
+	"search":        true,
+	"settings":      true,
+	"signin":        true,
+	"signout":       true,
+	"signup":        true,
+	"staff":         true,
+	"static":        true,
+	"support":       true,
+	"system":        true,
+	"u":             true,
+	"users":         true,
+	"www":           true,
+}
+
+// NormalizeUsername trims and lowercases a username. Usernames are stored
+// normalized so that they are unique regardless of case.
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// CheckUsername returns ErrInvalidUsername or ErrUsernameReserved if a
+// normalized username can't be used.
+func CheckUsername(username string) error {
+	if len(username) < MinUsernameLength || len(username) > MaxUsernameLength || !usernamePattern.MatchString(username) {
+		return ErrInvalidUsername
+	}
+	if reservedUsernames[username] {
+		return ErrUsernameReserved
+	}
+
+	return nil
+}
+
+// ByUsername finds the user with a username, or the user who last had it
+// before changing it. Compare the returned user's Username to tell the two
+// apart.
+func (us *UserService) ByUsername(username string) (*User, error) {
+	username = NormalizeUsername(username)
+
+	var user User
+	row := us.DB.QueryRow(
+		`
+		SELECT users.id, users.username, users.display_name, users.bio, users.avatar
+		FROM users
+		WHERE users.username = $1
+		UNION ALL
+		SELECT users.id, users.username, users.display_name, users.bio, users.avatar
+		FROM username_redirects
+			JOIN users ON users.id = username_redirects.user_id
+		WHERE username_redirects.username = $1 AND users.username IS NOT NULL
+		LIMIT 1;`,
+		username,
+	)
+	err := row.Scan(&user.ID, &user.Username, &user.DisplayName, &user.Bio, &user.Avatar)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("user by username: %w", err)
+	}
+
+	return &user, nil
+}
+
+// UpdateProfile saves the username, display name and bio. A username that
+// is given up keeps pointing at the user, so that links to their profile
+// still work and nobody else can take it over, until they have given up
+// MaxUsernameRedirects newer ones.
+func (us *UserService) UpdateProfile(user *User) error {
+	user.Username = NormalizeUsername(user.Username)
+	user.DisplayName = strings.TrimSpace(user.DisplayName)
+	user.Bio = strings.TrimSpace(user.Bio)
+
+	err := CheckUsername(user.Username)
+	if err != nil {
+		return fmt.Errorf("update profile: %w", err)
+	}
+
+	tx, err := us.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("update profile: %w", err)
+	}
+	defer tx.Rollback()
+
+	var oldUsername sql.NullString
+	err = tx.QueryRow(
+		`
+		SELECT username
+		FROM users
+		WHERE id = $1
+		FOR UPDATE;`,
+		user.ID,
+	).Scan(&oldUsername)
+	if err != nil {
+		return fmt.Errorf("update profile: %w", err)
+	}
+
+	if user.Username != oldUsername.String {
+		var ownerID int
+		err = tx.QueryRow(
+			`
+			SELECT user_id
+			FROM username_redirects
+			WHERE username = $1;`,
+			user.Username,
+		).Scan(&ownerID)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+		case err != nil:
+			return fmt.Errorf("update profile: %w", err)
+		case ownerID != user.ID:
+			return fmt.Errorf("update profile: %w", ErrUsernameTaken)
+		}
+
+		// Taking back an old username turns its redirect back into the
+		// real thing.
+		_, err = tx.Exec(
+			`
+			DELETE FROM username_redirects
+			WHERE username = $1;`,
+			user.Username,
+		)
+		if err != nil {
+			return fmt.Errorf("update profile: %w", err)
+		}
+
+		if oldUsername.Valid {
+			_, err = tx.Exec(
+				`
+				INSERT INTO username_redirects (username, user_id)
+				VALUES ($1, $2);`,
+				oldUsername.String,
+				user.ID,
+			)
+			if err != nil {
+				return fmt.Errorf("update profile: %w", err)
+			}
+
+			_, err = tx.Exec(
+				`
+				DELETE FROM username_redirects
+				WHERE user_id = $1 AND username NOT IN (
+					SELECT username
+					FROM username_redirects
+					WHERE user_id = $1
+					ORDER BY created_at DESC
+					LIMIT $2
+				);`,
+				user.ID,
+				MaxUsernameRedirects,
+			)
+			if err != nil {
+				return fmt.Errorf("update profile: %w", err)
+			}
+		}
+	}
+
+	_, err = tx.Exec(
+		`
+		UPDATE users
+		SET username = $2, display_name = $3, bio = $4
+		WHERE id = $1;`,
+		user.ID,
+		user.Username,
+		user.DisplayName,
+		user.Bio,
+	)
+	if err != nil {
+		var pgError *pgconn.PgError
+		if errors.As(err, &pgError) && pgError.Code == pgerrcode.UniqueViolation {
+			return fmt.Errorf("update profile: %w", ErrUsernameTaken)
+		}
+		return fmt.Errorf("update profile: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("update profile: %w", err)
+	}
+
+	return nil
+}
+
+// SetAvatar crops contents to a square and scales it to AvatarSize, in the
+// same way gallery thumbnails are made, and makes it the user's avatar. It
+// returns the avatar's filename.
+func (us *UserService) SetAvatar(userID int, contents io.ReadSeeker) (string, error) {
+	err := checkContentType(contents, []string{"image/png", "image/jpeg", "image/gif"})
+	if err != nil {
+		return "", fmt.Errorf("set avatar: %w", err)
+	}
+
+	cfg, _, err := image.DecodeConfig(contents)
+	if err != nil {
+		return "", fmt.Errorf("set avatar: %w", FileError{Issue: err.Error()})
+	}
+	if cfg.Width*cfg.Height > maxDecodePixels {
+		return "", fmt.Errorf("set avatar: %w", FileError{Issue: "image is too large"})
+	}
+
+	_, err = contents.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", fmt.Errorf("set avatar: %w", err)
+	}
+
+	src, _, err := image.Decode(contents)
+	if err != nil {
+		return "", fmt.Errorf("set avatar: %w", FileError{Issue: err.Error()})
+	}
+
+	key, err := rand.Bytes(16)
+	if err != nil {
+		return "", fmt.Errorf("set avatar: %w", err)
+	}
+	filename := hex.EncodeToString(key) + ".jpg"
+
+	err = writeThumbnail(us.AvatarPath(filename), thumbnail(squareCrop(src), AvatarSize))
+	if err != nil {
+		return "", fmt.Errorf("set avatar: %w", err)
+	}
+
+	err = us.replaceAvatar(userID, filename)
+	if err != nil {
+		os.Remove(us.AvatarPath(filename))
+		return "", fmt.Errorf("set avatar: %w", err)
+	}
+
+	return filename, nil
+}
+
+func (us *UserService) RemoveAvatar(userID int) error {
+	err := us.replaceAvatar(userID, "")
+	if err != nil {
+		return fmt.Errorf("remove avatar: %w", err)
+	}
+
+	return nil
+}
+
+// replaceAvatar records filename as the user's avatar and deletes the file
+// of the one it replaces.
+func (us *UserService) replaceAvatar(userID int, filename string) error {
+	var old string
+	err := us.DB.QueryRow(
+		`
+		UPDATE users
+		SET avatar = $2
+		FROM (SELECT avatar FROM users WHERE id = $1 FOR UPDATE) old
+		WHERE users.id = $1
+		RETURNING old.avatar;`,
+		userID,
+		filename,
+	).Scan(&old)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	if old != "" && old != filename {
+		err = os.Remove(us.AvatarPath(old))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AvatarPath is where the avatar with filename is stored.
+func (us *UserService) AvatarPath(filename string) string {
+	imagesDir := us.ImagesDir
+	if imagesDir == "" {
+		imagesDir = "images"
+	}
+
+	return filepath.Join(imagesDir, "avatars", filepath.Base(filename))
+}
+
+// squareCrop cuts the largest centered square out of src. Images of a type
+// that can't be cropped are returned whole.
+func squareCrop(src image.Image) image.Image {
+	b := src.Bounds()
+	size := b.Dx()
+	if b.Dy() < size {
+		size = b.Dy()
+	}
+	x := b.Min.X + (b.Dx()-size)/2
+	y := b.Min.Y + (b.Dy()-size)/2
+
+	sub, ok := src.(interface {
+		SubImage(r image.Rectangle) image.Image
+	})
+	if !ok {
+		return src
+	}
+
+	return sub.SubImage(image.Rect(x, y, x+size, y+size))
+}
@@ -0,0 +1,166 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ViewWindow is how long repeat views of a gallery or image by the same
+// visitor aren't counted again.
+const ViewWindow = 30 * time.Minute
+
+// ViewCounter counts views of galleries and images. Views are kept in memory
+// until Flush writes them, so counting one never waits for the database.
+// Views that haven't been flushed are lost if the process exits, so Flush
+// should be called once more on shutdown.
+type ViewCounter struct {
+	DB *sql.DB
+
+	mu        sync.Mutex
+	seen      map[viewKey]time.Time
+	galleries map[int]int
+	images    map[int]int
+}
+
+type viewKey struct {
+	image   bool
+	id      int
+	visitor string
+}
+
+// GalleryView counts a view of the gallery, unless the visitor has been
+// counted within ViewWindow. Visitors can be identified by anything stable,
+// such as their user ID or address.
+func (vc *ViewCounter) GalleryView(galleryID int, visitor string) {
+	vc.count(viewKey{id: galleryID, visitor: visitor})
+}
+
+// ImageView counts a view of the image like GalleryView.
+func (vc *ViewCounter) ImageView(imageID int, visitor string) {
+	vc.count(viewKey{image: true, id: imageID, visitor: visitor})
+}
+
+func (vc *ViewCounter) count(key viewKey) {
+	now := time.Now()
+
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	if seen, ok := vc.seen[key]; ok && now.Sub(seen) < ViewWindow {
+		return
+	}
+
+	if vc.seen == nil {
+		vc.seen = make(map[viewKey]time.Time)
+	}
+	vc.seen[key] = now
+
+	if key.image {
+		if vc.images == nil {
+			vc.images = make(map[int]int)
+		}
+		vc.images[key.id]++
+	} else {
+		if vc.galleries == nil {
+			vc.galleries = make(map[int]int)
+		}
+		vc.galleries[key.id]++
+	}
+}
+
+// Flush writes the views counted since the last flush in one transaction.
+// If that fails, they are kept for the next flush.
+func (vc *ViewCounter) Flush() error {
+	now := time.Now()
+
+	vc.mu.Lock()
+	galleries, images := vc.galleries, vc.images
+	vc.galleries, vc.images = nil, nil
+	for key, seen := range vc.seen {
+		if now.Sub(seen) >= ViewWindow {
+			delete(vc.seen, key)
+		}
+	}
+	vc.mu.Unlock()
+
+	if len(galleries) == 0 && len(images) == 0 {
+		return nil
+	}
+
+	err := vc.write(galleries, images)
+	if err != nil {
+		vc.mu.Lock()
+		vc.galleries = addCounts(vc.galleries, galleries)
+		vc.images = addCounts(vc.images, images)
+		vc.mu.Unlock()
+		return fmt.Errorf("flush views: %w", err)
+	}
+
+	return nil
+}
+
+func (vc *ViewCounter) write(galleries, images map[int]int) error {
+	tx, err := vc.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for id, n := range galleries {
+		_, err = tx.Exec(
+			`
+			UPDATE galleries
+			SET views = views + $2
+			WHERE id = $1;`,
+			id,
+			n,
+		)
+		if err != nil {
+			return err
+		}
+
+		// Selecting the gallery rather than inserting its ID skips
+		// galleries that were deleted since they were viewed.
+		_, err = tx.Exec(
+			`
+			INSERT INTO gallery_daily_views (gallery_id, day, views)
+			SELECT id, CURRENT_DATE, $2 FROM galleries WHERE id = $1
+			ON CONFLICT (gallery_id, day) DO UPDATE
+			SET views = gallery_daily_views.views + EXCLUDED.views;`,
+			id,
+			n,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	for id, n := range images {
+		_, err = tx.Exec(
+			`
+			UPDATE images
+			SET views = views + $2
+			WHERE id = $1;`,
+			id,
+			n,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func addCounts(counts, more map[int]int) map[int]int {
+	if counts == nil {
+		counts = make(map[int]int)
+	}
+	for id, n := range more {
+		counts[id] += n
+	}
+
+	return counts
+}
@@ -0,0 +1,45 @@
+// Package urls builds links to pages of the app. Paths passed in are
+// relative to the app root, such as "/galleries/1"; the public base URL
+// of the request, including any path prefix the reverse proxy strips,
+// is added in front of them.
+package urls
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/IrakliGiorgadze/go-web-app/context"
+)
+
+// Path returns the path to use in links and redirects.
+func Path(r *http.Request, path string) string {
+	return base(r).Path + path
+}
+
+// Absolute returns the full URL, for links that leave the site such as
+// the ones in emails.
+func Absolute(r *http.Request, path string) string {
+	b := base(r)
+	return b.Scheme + "://" + b.Host + b.Path + path
+}
+
+func Redirect(w http.ResponseWriter, r *http.Request, path string, code int) {
+	http.Redirect(w, r, Path(r, path), code)
+}
+
+// base falls back to the request itself when no base URL was set.
+func base(r *http.Request) *url.URL {
+	if b := context.BaseURL(r.Context()); b != nil {
+		return b
+	}
+
+	b := url.URL{
+		Scheme: "http",
+		Host:   r.Host,
+	}
+	if r.TLS != nil {
+		b.Scheme = "https"
+	}
+
+	return &b
+}
@@ -0,0 +1,186 @@
+// Package validate checks submitted form values and collects the problems
+// per field, so that forms can be shown again with a message next to each
+// field that needs fixing.
+package validate
+
+import (
+	"fmt"
+	"net/mail"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Rule checks a single value. It returns a message describing the problem,
+// or the zero Message if the value is valid.
+type Rule func(value string) Message
+
+// Message describes a problem. The format and args are kept apart so that
+// the format can be translated before the args are filled in.
+type Message struct {
+	Format string
+	Args   []any
+}
+
+func Msgf(format string, args ...any) Message {
+	return Message{Format: format, Args: args}
+}
+
+func (m Message) String() string {
+	if len(m.Args) == 0 {
+		return m.Format
+	}
+
+	return fmt.Sprintf(m.Format, m.Args...)
+}
+
+// Errors maps field names to the problems found with them.
+type Errors map[string][]Message
+
+func (e Errors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var parts []string
+	for _, field := range fields {
+		var msgs []string
+		for _, msg := range e[field] {
+			msgs = append(msgs, msg.String())
+		}
+		parts = append(parts, fmt.Sprintf("%s: %s", field, strings.Join(msgs, " ")))
+	}
+
+	return "validate: " + strings.Join(parts, "; ")
+}
+
+func (e Errors) Public() string {
+	return "Please fix the problems highlighted below."
+}
+
+// Fields exposes the messages to templates.
+func (e Errors) Fields() map[string][]Message {
+	return e
+}
+
+type Validator struct {
+	errs Errors
+}
+
+// Field checks value against each rule in turn, stopping at the first one
+// that fails.
+func (v *Validator) Field(name, value string, rules ...Rule) {
+	for _, rule := range rules {
+		if msg := rule(value); msg.Format != "" {
+			v.add(name, msg)
+			return
+		}
+	}
+}
+
+// Add records a problem that was found some other way, such as by a
+// service.
+func (v *Validator) Add(name, format string, args ...any) {
+	v.add(name, Msgf(format, args...))
+}
+
+func (v *Validator) add(name string, msg Message) {
+	if v.errs == nil {
+		v.errs = make(Errors)
+	}
+	v.errs[name] = append(v.errs[name], msg)
+}
+
+func (v *Validator) Valid() bool {
+	return len(v.errs) == 0
+}
+
+// Err returns the problems found as Errors, or nil if there were none.
+func (v *Validator) Err() error {
+	if v.Valid() {
+		return nil
+	}
+
+	return v.errs
+}
+
+// Required rejects values that are empty or only whitespace.
+func Required() Rule {
+	return func(value string) Message {
+		if strings.TrimSpace(value) == "" {
+			return Msgf("This field is required.")
+		}
+		return Message{}
+	}
+}
+
+// MinLength and MaxLength count characters rather than bytes.
+func MinLength(n int) Rule {
+	return func(value string) Message {
+		if utf8.RuneCountInString(value) < n {
+			return Msgf("Must be at least %d characters long.", n)
+		}
+		return Message{}
+	}
+}
+
+func MaxLength(n int) Rule {
+	return func(value string) Message {
+		if utf8.RuneCountInString(value) > n {
+			return Msgf("Must be at most %d characters long.", n)
+		}
+		return Message{}
+	}
+}
+
+// MaxBytes is for limits that apply to the encoded value, such as the 72
+// bytes bcrypt uses of a password.
+func MaxBytes(n int) Rule {
+	return func(value string) Message {
+		if len(value) > n {
+			return Msgf("Is too long.")
+		}
+		return Message{}
+	}
+}
+
+// Email accepts a bare address such as "jon@example.com", without a
+// display name.
+func Email() Rule {
+	return func(value string) Message {
+		addr, err := mail.ParseAddress(value)
+		if err != nil || addr.Name != "" || addr.Address != strings.TrimSpace(value) || !strings.Contains(addr.Address, "@") {
+			return Msgf("Must be a valid email address.")
+		}
+		return Message{}
+	}
+}
+
+// Equals requires the value to match another, such as a password and its
+// confirmation.
+func Equals(other, msg string) Rule {
+	return func(value string) Message {
+		if value != other {
+			return Msgf(msg)
+		}
+		return Message{}
+	}
+}
+
+// MaxItems limits the number of comma separated items in a value.
+func MaxItems(n int) Rule {
+	return func(value string) Message {
+		count := 0
+		for _, item := range strings.Split(value, ",") {
+			if strings.TrimSpace(item) != "" {
+				count++
+			}
+		}
+		if count > n {
+			return Msgf("Can have at most %d items.", n)
+		}
+		return Message{}
+	}
+}
@@ -0,0 +1,142 @@
+package views
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// sourceContext is the number of lines shown either side of the line a
+// template error points at.
+const sourceContext = 5
+
+// Template errors start with the file and line, such as
+// "template: signup.gohtml:23:5: executing ...".
+var templateErrLocation = regexp.MustCompile(`template: ([^:\s]+):(\d+)`)
+
+var devErrorTpl = template.Must(template.New("dev-error").Parse(`<!doctype html>
+<html>
+<head><meta charset="utf-8"><title>Template error</title></head>
+<body style="font-family: sans-serif; margin: 2rem">
+<h1 style="color: #b91c1c">Template error</h1>
+<pre style="white-space: pre-wrap">{{.Err}}</pre>
+{{if .Lines}}
+<h2>{{.File}}</h2>
+<pre style="background: #f3f4f6; padding: 1rem">{{range .Lines}}<span{{if .Current}} style="background: #fecaca"{{end}}>{{printf "%4d" .Number}}  {{.Text}}</span>
+{{end}}</pre>
+{{end}}
+</body>
+</html>
+`))
+
+type sourceLine struct {
+	Number  int
+	Text    string
+	Current bool
+}
+
+// renderDevError shows a template error and the lines around it. It is only
+// used in development, as it reveals the template source.
+func (t Template) renderDevError(w http.ResponseWriter, err error) {
+	log.Printf("rendering template: %v", err)
+
+	var data struct {
+		Err   string
+		File  string
+		Lines []sourceLine
+	}
+	data.Err = err.Error()
+
+	if m := templateErrLocation.FindStringSubmatch(err.Error()); m != nil {
+		line, _ := strconv.Atoi(m[2])
+		data.File = t.findFile(m[1])
+		if data.File != "" {
+			data.Lines = t.sourceLines(data.File, line)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusInternalServerError)
+	err = devErrorTpl.Execute(w, data)
+	if err != nil {
+		log.Printf("dev error page: %v", err)
+	}
+}
+
+// findFile returns the path of the file a template was parsed from. Errors
+// only name the file's base name.
+func (t Template) findFile(name string) string {
+	for _, pattern := range t.patterns {
+		matches, err := fs.Glob(t.fs, pattern)
+		if err != nil {
+			continue
+		}
+		for _, match := range matches {
+			if path.Base(match) == name {
+				return match
+			}
+		}
+	}
+
+	return ""
+}
+
+func (t Template) sourceLines(file string, line int) []sourceLine {
+	b, err := fs.ReadFile(t.fs, file)
+	if err != nil {
+		return nil
+	}
+
+	var lines []sourceLine
+	for i, text := range strings.Split(string(b), "\n") {
+		n := i + 1
+		if n < line-sourceContext || n > line+sourceContext {
+			continue
+		}
+		lines = append(lines, sourceLine{
+			Number:  n,
+			Text:    text,
+			Current: n == line,
+		})
+	}
+
+	return lines
+}
+
+// Check parses every .gohtml file in fsys together with the layouts, such
+// as "tailwind.gohtml", and reports every one that fails. Run it at boot so
+// that a broken template is found before a user requests its page.
+func Check(fsys fs.FS, layouts ...string) error {
+	isLayout := make(map[string]bool)
+	for _, layout := range layouts {
+		isLayout[layout] = true
+	}
+
+	var errs []error
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || path.Ext(p) != ".gohtml" || isLayout[p] {
+			return nil
+		}
+
+		_, err = parse(fsys, append([]string{p}, layouts...)...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p, err))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("check templates: %w", err)
+	}
+
+	return errors.Join(errs...)
+}
@@ -10,9 +10,14 @@ import (
 	"log"
 	"net/http"
 	"path"
+	"time"
 
 	"github.com/IrakliGiorgadze/go-web-app/context"
+	"github.com/IrakliGiorgadze/go-web-app/flash"
+	"github.com/IrakliGiorgadze/go-web-app/i18n"
 	"github.com/IrakliGiorgadze/go-web-app/models"
+	"github.com/IrakliGiorgadze/go-web-app/urls"
+	"github.com/IrakliGiorgadze/go-web-app/validate"
 
 	"github.com/gorilla/csrf"
 )
@@ -21,11 +26,49 @@ type public interface {
 	Public() string
 }
 
+// fielder is implemented by errors that apply to individual form fields,
+// such as validate.Errors.
+type fielder interface {
+	Fields() map[string][]validate.Message
+}
+
 type Template struct {
 	htmlTpl *template.Template
+
+	// Set when parsed by a Parser with Reload.
+	fs       fs.FS
+	patterns []string
+	reload   bool
+}
+
+// Parser parses page templates from FS. In production FS is the embedded
+// templates.FS. In development it can be the templates directory on disk
+// with Reload set, so that edits show up on the next request without a
+// restart, and template errors are shown in the browser.
+type Parser struct {
+	FS     fs.FS
+	Reload bool
+}
+
+func (p Parser) Parse(patterns ...string) (Template, error) {
+	htmlTpl, err := parse(p.FS, patterns...)
+	if err != nil {
+		return Template{}, err
+	}
+
+	return Template{
+		htmlTpl:  htmlTpl,
+		fs:       p.FS,
+		patterns: patterns,
+		reload:   p.Reload,
+	}, nil
 }
 
 func ParseFS(fs fs.FS, patterns ...string) (Template, error) {
+	return Parser{FS: fs}.Parse(patterns...)
+}
+
+func parse(fs fs.FS, patterns ...string) (*template.Template, error) {
 	tpl := template.New(path.Base(patterns[0]))
 	tpl = tpl.Funcs(
 		template.FuncMap{
@@ -38,28 +81,56 @@ func ParseFS(fs fs.FS, patterns ...string) (Template, error) {
 			"errors": func() []string {
 				return nil
 			},
+			"basePath": func() string {
+				return ""
+			},
+			"fieldErrors": func(name string) []string {
+				return nil
+			},
+			"flashes": func() []flash.Message {
+				return nil
+			},
+			"t": func(key string, args ...any) string {
+				return key
+			},
+			"date": func(t time.Time) string {
+				return ""
+			},
+			"currentPath": func() string {
+				return ""
+			},
 		},
 	)
 
 	tpl, err := tpl.ParseFS(fs, patterns...)
 	if err != nil {
-		return Template{}, fmt.Errorf("parseFS template: %w", err)
+		return nil, fmt.Errorf("parseFS template: %w", err)
 	}
 
-	return Template{
-		htmlTpl: tpl,
-	}, nil
+	return tpl, nil
 }
 
 func (t Template) Execute(w http.ResponseWriter, r *http.Request, data any, errs ...error) {
-	tpl, err := t.htmlTpl.Clone()
+	htmlTpl := t.htmlTpl
+	if t.reload {
+		var err error
+		htmlTpl, err = parse(t.fs, t.patterns...)
+		if err != nil {
+			t.renderDevError(w, err)
+			return
+		}
+	}
+
+	tpl, err := htmlTpl.Clone()
 	if err != nil {
 		log.Printf("cloning template: %v", err)
 		http.Error(w, "There was an error rendering the page.", http.StatusInternalServerError)
 		return
 	}
 
-	errMsgs := errMessages(errs...)
+	localizer := context.Localizer(r.Context())
+	errMsgs := errMessages(localizer, errs...)
+	fieldMsgs := fieldMessages(localizer, errs...)
 
 	tpl = tpl.Funcs(
 		template.FuncMap{
@@ -72,6 +143,24 @@ func (t Template) Execute(w http.ResponseWriter, r *http.Request, data any, errs
 			"errors": func() []string {
 				return errMsgs
 			},
+			// basePath goes in front of every link to the app, so that
+			// it works behind a reverse proxy that adds a path prefix.
+			"basePath": func() string {
+				return urls.Path(r, "")
+			},
+			"fieldErrors": func(name string) []string {
+				return fieldMsgs[name]
+			},
+			"flashes": func() []flash.Message {
+				return context.Flashes(r.Context())
+			},
+			"t":    localizer.T,
+			"date": localizer.Date,
+			// currentPath is relative to the app root, like the paths
+			// given to basePath.
+			"currentPath": func() string {
+				return r.URL.RequestURI()
+			},
 		},
 	)
 
@@ -81,6 +170,10 @@ func (t Template) Execute(w http.ResponseWriter, r *http.Request, data any, errs
 
 	err = tpl.Execute(&buf, data)
 	if err != nil {
+		if t.reload {
+			t.renderDevError(w, err)
+			return
+		}
 		log.Printf("parsing template: %v", err)
 		http.Error(w, "There was an error executing the template", http.StatusInternalServerError)
 		return
@@ -89,14 +182,30 @@ func (t Template) Execute(w http.ResponseWriter, r *http.Request, data any, errs
 	_, _ = io.Copy(w, &buf)
 }
 
-func errMessages(errs ...error) []string {
+func errMessages(localizer *i18n.Localizer, errs ...error) []string {
 	var msgs []string
 	for _, err := range errs {
 		var pubErr public
 		if errors.As(err, &pubErr) {
-			msgs = append(msgs, pubErr.Public())
+			msgs = append(msgs, localizer.T(pubErr.Public()))
 		} else {
-			msgs = append(msgs, "Something went wrong")
+			msgs = append(msgs, localizer.T("Something went wrong"))
+		}
+	}
+
+	return msgs
+}
+
+func fieldMessages(localizer *i18n.Localizer, errs ...error) map[string][]string {
+	msgs := make(map[string][]string)
+	for _, err := range errs {
+		var f fielder
+		if errors.As(err, &f) {
+			for field, fieldMsgs := range f.Fields() {
+				for _, msg := range fieldMsgs {
+					msgs[field] = append(msgs[field], localizer.T(msg.Format, msg.Args...))
+				}
+			}
 		}
 	}
 
@@ -0,0 +1,170 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/IrakliGiorgadze/go-web-app/models"
+)
+
+const (
+	DefaultWorkers      = 2
+	DefaultPollInterval = time.Second
+)
+
+// HandlerFunc runs a job. Returning an error schedules a retry, unless it
+// wraps models.ErrJobFailed.
+type HandlerFunc func(job *models.Job) error
+
+// Pool claims jobs from the JobService and runs them on a fixed number of
+// goroutines. Pools can run in the web server, in cmd/worker, or both; jobs
+// are only claimed for kinds that have a handler.
+type Pool struct {
+	JobService   *models.JobService
+	Workers      int
+	PollInterval time.Duration
+
+	handlers map[string]HandlerFunc
+	periodic []periodic
+}
+
+type periodic struct {
+	kind     string
+	interval time.Duration
+}
+
+func (p *Pool) Handle(kind string, h HandlerFunc) {
+	if p.handlers == nil {
+		p.handlers = make(map[string]HandlerFunc)
+	}
+	p.handlers[kind] = h
+}
+
+// Every queues a job of the given kind once per interval. The job is keyed
+// by its kind, so pools in several processes don't queue it more than once.
+func (p *Pool) Every(interval time.Duration, kind string) {
+	p.periodic = append(p.periodic, periodic{
+		kind:     kind,
+		interval: interval,
+	})
+}
+
+// Run processes jobs until ctx is cancelled, then waits for the jobs that
+// are running to finish.
+func (p *Pool) Run(ctx context.Context) error {
+	if len(p.handlers) == 0 {
+		return fmt.Errorf("run workers: no job handlers")
+	}
+
+	var kinds []string
+	for kind := range p.handlers {
+		kinds = append(kinds, kind)
+	}
+
+	var wg sync.WaitGroup
+	for _, job := range p.periodic {
+		wg.Add(1)
+		go func(job periodic) {
+			defer wg.Done()
+			p.schedule(ctx, job)
+		}(job)
+	}
+
+	for i := 0; i < p.workers(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(ctx, kinds)
+		}()
+	}
+
+	wg.Wait()
+
+	return nil
+}
+
+func (p *Pool) work(ctx context.Context, kinds []string) {
+	for {
+		job, err := p.JobService.Claim(kinds)
+		if err != nil && !errors.Is(err, models.ErrNotFound) {
+			log.Println("Cannot claim job", err)
+		}
+
+		if job == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(p.pollInterval()):
+			}
+			continue
+		}
+
+		err = p.runJob(job)
+		if err != nil {
+			log.Printf("Job %d (%s) failed on attempt %d: %v", job.ID, job.Kind, job.Attempts, err)
+			err = p.JobService.Fail(job, err)
+		} else {
+			err = p.JobService.Complete(job)
+		}
+		if err != nil {
+			log.Println("Cannot update job", err)
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// runJob turns a panicking handler into a failed job rather than a crashed
+// process.
+func (p *Pool) runJob(job *models.Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return p.handlers[job.Kind](job)
+}
+
+func (p *Pool) schedule(ctx context.Context, job periodic) {
+	ticker := time.NewTicker(job.interval)
+	defer ticker.Stop()
+
+	for {
+		// Jobs are scheduled for the start of the next interval so that
+		// every pool agrees on when it runs.
+		runAt := time.Now().Truncate(job.interval).Add(job.interval)
+		err := p.JobService.Schedule(job.kind, job.kind, nil, runAt)
+		if err != nil {
+			log.Println("Cannot schedule job", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Pool) workers() int {
+	if p.Workers <= 0 {
+		return DefaultWorkers
+	}
+
+	return p.Workers
+}
+
+func (p *Pool) pollInterval() time.Duration {
+	if p.PollInterval <= 0 {
+		return DefaultPollInterval
+	}
+
+	return p.PollInterval
+}